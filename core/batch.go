@@ -0,0 +1,123 @@
+package core
+
+import (
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// BatchConfig represents the policy closing batches of entries: whichever
+// limit is reached first. Zero sizes mean no limit.
+type BatchConfig struct {
+	// Maximum number of entries of a batch.
+	MaxEntries int
+
+	// Maximum size of a batch, once encoded.
+	MaxBytes int
+
+	// Maximum time entries wait in a batch before it's published.
+	MaxAge time.Duration
+}
+
+// NewBatchConfig creates a BatchConfig closing batches after maxEntries
+// entries or 5 seconds.
+func NewBatchConfig(maxEntries int) BatchConfig {
+	return BatchConfig{
+		MaxEntries: maxEntries,
+		MaxAge:     5 * time.Second,
+	}
+}
+
+// limit lowers the sizes of c to the hard limits of a sink.
+func (c BatchConfig) limit(limits BatchConfig) BatchConfig {
+	if limits.MaxEntries > 0 && (c.MaxEntries <= 0 || c.MaxEntries > limits.MaxEntries) {
+		c.MaxEntries = limits.MaxEntries
+	}
+	if limits.MaxBytes > 0 && (c.MaxBytes <= 0 || c.MaxBytes > limits.MaxBytes) {
+		c.MaxBytes = limits.MaxBytes
+	}
+	return c
+}
+
+// BatchLimiter is implemented by provider configs whose sinks reject batches
+// over some size. MaxAge is ignored.
+type BatchLimiter interface {
+	BatchLimits() BatchConfig
+}
+
+// EntrySizer is implemented by providers to tell how many bytes an entry
+// takes in a batch once encoded. Otherwise the JSON encoding is measured.
+// Entries are measured once, as they're added, and only their size is kept
+// with the batch: they're encoded again as the batch is published, so that
+// batches never hold the encoded entries.
+type EntrySizer interface {
+	EntrySize(e *sdjournal.JournalEntry) int
+}
+
+// batch is a set of entries published together.
+type batch struct {
+	entries []*sdjournal.JournalEntry
+	cursors []string
+	sizes   []int
+	bytes   int
+	created time.Time
+}
+
+func (b *batch) Len() int {
+	return len(b.entries)
+}
+
+// add appends e, cursor being the one to persist once it's published.
+func (b *batch) add(e *sdjournal.JournalEntry, cursor string, size int) {
+	if len(b.entries) == 0 {
+		b.created = time.Now()
+	}
+	b.entries = append(b.entries, e)
+	b.cursors = append(b.cursors, cursor)
+	b.sizes = append(b.sizes, size)
+	b.bytes += size
+}
+
+// remove drops the first n entries.
+func (b *batch) remove(n int) {
+	for _, size := range b.sizes[:n] {
+		b.bytes -= size
+	}
+	rest := copy(b.entries, b.entries[n:])
+	for i := rest; i < len(b.entries); i++ {
+		b.entries[i] = nil
+	}
+	b.entries = b.entries[:rest]
+	b.cursors = b.cursors[:copy(b.cursors, b.cursors[n:])]
+	b.sizes = b.sizes[:copy(b.sizes, b.sizes[n:])]
+}
+
+// full reports whether adding an entry of size bytes would exceed config.
+func (b *batch) full(config BatchConfig, size int) bool {
+	if config.MaxEntries > 0 && len(b.entries) >= config.MaxEntries {
+		return true
+	}
+	return config.MaxBytes > 0 && len(b.entries) > 0 && b.bytes+size > config.MaxBytes
+}
+
+func (b *batch) Iterator() JournalEntryIterator {
+	return &batchIterator{entries: b.entries}
+}
+
+// batchIterator iterates over the entries of a batch, numbering them from 1.
+type batchIterator struct {
+	entries []*sdjournal.JournalEntry
+	i       int
+}
+
+func (it *batchIterator) Next() bool {
+	if it.i >= len(it.entries) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *batchIterator) Value() (int, *sdjournal.JournalEntry) {
+	return it.i, it.entries[it.i-1]
+}
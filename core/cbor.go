@@ -0,0 +1,124 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborSimple = 7 << 5
+)
+
+// CBOREncoder encodes entries as CBOR (RFC 7049) maps, and batches of them as
+// arrays. Values which aren't valid UTF-8 are encoded as byte strings.
+type CBOREncoder struct {
+	mapper  entryMapper
+	buf     []byte
+	scratch [8]byte
+}
+
+// NewCBOREncoder creates a CBOREncoder configured with config.
+func NewCBOREncoder(config MarshallerConfig) *CBOREncoder {
+	return &CBOREncoder{mapper: newEntryMapper(config)}
+}
+
+func (m *CBOREncoder) MarshalOne(e *sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.mapper.writeEntry(m, e)
+	return m.buf
+}
+
+func (m *CBOREncoder) MarshalAll(ea []*sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.writeHead(cborArray, uint64(len(ea)))
+	for _, e := range ea {
+		m.mapper.writeEntry(m, e)
+	}
+	return m.buf
+}
+
+func (m *CBOREncoder) batchStart(n int) []byte {
+	m.buf = m.buf[:0]
+	m.writeHead(cborArray, uint64(n))
+	return m.buf
+}
+
+func (m *CBOREncoder) batchEntry(i int, e *sdjournal.JournalEntry) []byte {
+	return m.MarshalOne(e)
+}
+
+func (m *CBOREncoder) batchEnd() []byte {
+	return nil
+}
+
+func (m *CBOREncoder) ContentType() string {
+	return "application/cbor"
+}
+
+// writeHead writes the initial bytes of a data item, using the shortest form
+// for n.
+func (m *CBOREncoder) writeHead(major byte, n uint64) {
+	switch {
+	case n < 24:
+		m.buf = append(m.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		binary.BigEndian.PutUint16(m.scratch[:], uint16(n))
+		m.buf = append(append(m.buf, major|25), m.scratch[:2]...)
+	case n <= math.MaxUint32:
+		binary.BigEndian.PutUint32(m.scratch[:], uint32(n))
+		m.buf = append(append(m.buf, major|26), m.scratch[:4]...)
+	default:
+		binary.BigEndian.PutUint64(m.scratch[:], n)
+		m.buf = append(append(m.buf, major|27), m.scratch[:8]...)
+	}
+}
+
+func (m *CBOREncoder) writeMapHeader(n int) {
+	m.writeHead(cborMap, uint64(n))
+}
+
+func (m *CBOREncoder) writeString(s string) {
+	m.writeHead(cborText, uint64(len(s)))
+	m.buf = append(m.buf, s...)
+}
+
+func (m *CBOREncoder) writeBytes(b string) {
+	m.writeHead(cborBytes, uint64(len(b)))
+	m.buf = append(m.buf, b...)
+}
+
+func (m *CBOREncoder) writeUint(v uint64) {
+	m.writeHead(cborUint, v)
+}
+
+func (m *CBOREncoder) writeInt(v int64) {
+	if v < 0 {
+		m.writeHead(cborNegint, uint64(-1-v))
+		return
+	}
+	m.writeHead(cborUint, uint64(v))
+}
+
+func (m *CBOREncoder) writeFloat(v float64) {
+	binary.BigEndian.PutUint64(m.scratch[:], math.Float64bits(v))
+	m.buf = append(append(m.buf, cborSimple|27), m.scratch[:8]...)
+}
+
+func (m *CBOREncoder) writeBool(v bool) {
+	if v {
+		m.buf = append(m.buf, cborSimple|21)
+	} else {
+		m.buf = append(m.buf, cborSimple|20)
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// checkpointer tells which cursor can be persisted once an entry emitted by
+// the pipeline is published: the cursor of the last entry received such that
+// neither it nor any entry received before is still held back by a stage or
+// being processed. Stages emit entries out of order while holding others back
+// (e.g. multiline groups), so the cursor of emitted entries can't be used.
+type checkpointer struct {
+	pipeline *Pipeline
+
+	// Cursor preceding the first entry of inputs.
+	before string
+
+	// Cursors received since, and the position of each of them, base being
+	// the position of the first one.
+	inputs []string
+	index  map[string]uint64
+	base   uint64
+
+	// Entry being run through the pipeline.
+	current *sdjournal.JournalEntry
+}
+
+func newCheckpointer(pipeline *Pipeline) *checkpointer {
+	return &checkpointer{
+		pipeline: pipeline,
+		index:    make(map[string]uint64),
+	}
+}
+
+// process runs e through the pipeline, checkpointing what it emits.
+func (c *checkpointer) process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry, string)) {
+	if !c.pipeline.holds() {
+		// Entries emitted later on, e.g. summaries flushed by a RateLimitStage,
+		// account for this one too.
+		c.before = e.Cursor
+		c.pipeline.Process(e, func(e *sdjournal.JournalEntry) {
+			emit(e, c.before)
+		})
+		return
+	}
+
+	c.index[e.Cursor] = c.base + uint64(len(c.inputs))
+	c.inputs = append(c.inputs, e.Cursor)
+	c.current = e
+	c.pipeline.Process(e, c.emitter(emit))
+	c.current = nil
+}
+
+// flush flushes the pipeline, checkpointing what it emits.
+func (c *checkpointer) flush(now time.Time, emit func(*sdjournal.JournalEntry, string)) {
+	c.pipeline.Flush(now, c.emitter(emit))
+}
+
+func (c *checkpointer) emitter(emit func(*sdjournal.JournalEntry, string)) func(*sdjournal.JournalEntry) {
+	return func(e *sdjournal.JournalEntry) {
+		emit(e, c.checkpoint(e))
+	}
+}
+
+// checkpoint returns the cursor which can be persisted once e is published.
+func (c *checkpointer) checkpoint(e *sdjournal.JournalEntry) string {
+	oldest, held := uint64(0), false
+	hold := func(cursor string) {
+		if i, ok := c.index[cursor]; ok && (!held || i < oldest) {
+			oldest, held = i, true
+		}
+	}
+	c.pipeline.held(hold)
+
+	// Unless it's passing through, the entry being processed may end up held.
+	if c.current != nil && c.current != e {
+		hold(c.current.Cursor)
+	}
+
+	if !held {
+		// Everything received is accounted for.
+		if n := len(c.inputs); n > 0 {
+			c.before = c.inputs[n-1]
+			c.forget(n)
+		}
+		return c.before
+	}
+
+	// Entries older than the oldest held one won't be held anymore.
+	if n := int(oldest - c.base); n > 0 {
+		c.before = c.inputs[n-1]
+		c.forget(n)
+	}
+	return c.before
+}
+
+// forget drops the first n inputs.
+func (c *checkpointer) forget(n int) {
+	for _, cursor := range c.inputs[:n] {
+		delete(c.index, cursor)
+	}
+	c.inputs = c.inputs[:copy(c.inputs, c.inputs[n:])]
+	c.base += uint64(n)
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// checkpointed is an entry emitted by the pipeline along with the cursor the
+// forwarder would persist once it's published.
+type checkpointed struct {
+	message    string
+	cursor     string
+	checkpoint string
+}
+
+type checkpointRecorder struct {
+	checkpointer *checkpointer
+	emitted      []checkpointed
+	seq          int
+}
+
+func newCheckpointRecorder(stages ...Stage) *checkpointRecorder {
+	return &checkpointRecorder{checkpointer: newCheckpointer(NewPipeline(stages...))}
+}
+
+func (r *checkpointRecorder) emit(e *sdjournal.JournalEntry, checkpoint string) {
+	r.emitted = append(r.emitted, checkpointed{e.Fields["MESSAGE"], e.Cursor, checkpoint})
+}
+
+// process runs an entry of unit logging message through the pipeline. Its
+// cursor is c<n>, n being its position.
+func (r *checkpointRecorder) process(unit, message string) {
+	r.seq++
+	r.checkpointer.process(&sdjournal.JournalEntry{
+		Cursor:            fmt.Sprintf("c%d", r.seq),
+		RealtimeTimestamp: uint64(r.seq),
+		Fields:            map[string]string{"_SYSTEMD_UNIT": unit, "MESSAGE": message},
+	}, r.emit)
+}
+
+func (r *checkpointRecorder) flush(now time.Time) {
+	r.checkpointer.flush(now, r.emit)
+}
+
+func (r *checkpointRecorder) assert(t *testing.T, want ...checkpointed) {
+	if len(r.emitted) != len(want) {
+		t.Fatalf("got %v, want %v", r.emitted, want)
+	}
+	for i := range want {
+		if r.emitted[i] != want[i] {
+			t.Fatalf("got %v, want %v", r.emitted, want)
+		}
+	}
+}
+
+func TestCheckpointerWithoutHolders(t *testing.T) {
+	r := newCheckpointRecorder()
+	r.process("a", "1")
+	r.process("b", "2")
+	r.assert(t,
+		checkpointed{"1", "c1", "c1"},
+		checkpointed{"2", "c2", "c2"},
+	)
+}
+
+func TestCheckpointerWithRateLimitSummary(t *testing.T) {
+	config := NewRateLimitConfig()
+	config.Rate = 0.001
+	config.Burst = 1
+	s, err := NewRateLimitStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newCheckpointRecorder(s)
+	r.process("a", "1")
+	r.process("a", "2")
+	r.process("a", "3")
+	r.flush(time.Time{})
+	if len(r.emitted) != 2 {
+		t.Fatalf("got %v, want an entry and a summary", r.emitted)
+	}
+	if r.emitted[0] != (checkpointed{"1", "c1", "c1"}) {
+		t.Fatalf("got %v", r.emitted[0])
+	}
+
+	// The summary, emitted last, must not drop the checkpoint.
+	if summary := r.emitted[1]; summary.checkpoint != "c3" {
+		t.Fatalf("summary checkpoint is %q, want c3", summary.checkpoint)
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressions of request bodies, named after their Content-Encoding.
+const (
+	CompressionNone   = ""
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// compressionNames lists the compressions supported by this build, zstd being
+// left out unless built with the zstd tag.
+func compressionNames() string {
+	if zstdSupported {
+		return "gzip, zstd or snappy"
+	}
+	return "gzip or snappy"
+}
+
+// compressor compresses whole payloads. Implementations are safe for
+// concurrent use.
+type compressor interface {
+	compress(dst *bytes.Buffer, src []byte) error
+}
+
+// streamCompressor is implemented by compressors able to compress payloads as
+// they're written. Closing the writer flushes what's left to dst.
+type streamCompressor interface {
+	writer(dst io.Writer) io.WriteCloser
+}
+
+// newCompressor creates a compressor for compression at level, 0 meaning the
+// default level of the algorithm.
+func newCompressor(compression string, level int) (compressor, error) {
+	switch compression {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+			return nil, err
+		}
+		return &gzipCompressor{level: level}, nil
+	case CompressionZstd:
+		return newZstdCompressor(level)
+	case CompressionSnappy:
+		if level != 0 {
+			return nil, fmt.Errorf("snappy has no compression levels")
+		}
+		return snappyCompressor{}, nil
+	}
+	return nil, fmt.Errorf("unknown compression: %s", compression)
+}
+
+type gzipCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) compress(dst *bytes.Buffer, src []byte) error {
+	w := c.writer(dst)
+	if _, err := w.Write(src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (c *gzipCompressor) writer(dst io.Writer) io.WriteCloser {
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(dst)
+	} else {
+		w, _ = gzip.NewWriterLevel(dst, c.level)
+	}
+	return &gzipPooledWriter{Writer: w, pool: &c.writers}
+}
+
+// gzipPooledWriter returns its writer to the pool once closed.
+type gzipPooledWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (w *gzipPooledWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) compress(dst *bytes.Buffer, src []byte) error {
+	dst.Write(snappyEncode(nil, src))
+	return nil
+}
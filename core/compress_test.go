@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testPayloads covers empty and tiny payloads, payloads spanning several
+// snappy blocks, long and overlapping matches and incompressible data.
+func testPayloads() map[string][]byte {
+	random := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	entries := newBenchmarkEntries(2000)
+	json := NewJournalEntryMarshallerWithConfig(NewMarshallerConfig()).MarshalAll(entries)
+
+	return map[string][]byte{
+		"empty":   {},
+		"short":   []byte("hello"),
+		"run":     bytes.Repeat([]byte{'a'}, 100000),
+		"pattern": []byte(strings.Repeat("abcdefgh", 20000)),
+		"far":     append(append(append([]byte{}, random[:3000]...), random[:50000]...), random[:3000]...),
+		"random":  random,
+		"entries": append([]byte{}, json...),
+	}
+}
+
+// snappyDecode decodes a snappy block, as described by
+// https://github.com/google/snappy/blob/master/format_description.txt.
+func snappyDecode(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errors.New("invalid length")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var offset, length int
+		switch tag & 3 {
+		case snappyTagLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errors.New("truncated literal length")
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if len(src) < length {
+				return nil, errors.New("truncated literal")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case snappyTagCopy1:
+			if len(src) < 2 {
+				return nil, errors.New("truncated copy")
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, errors.New("truncated copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		default:
+			return nil, errors.New("unexpected copy with 4 byte offset")
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errors.New("invalid copy offset")
+		}
+		// Copies may overlap what they append.
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errors.New("length mismatch")
+	}
+	return dst, nil
+}
+
+func gzipDecode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func testCompressorRoundTrip(t *testing.T, compression string, level int, decode func([]byte) ([]byte, error)) {
+	c, err := newCompressor(compression, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, payload := range testPayloads() {
+		var buf bytes.Buffer
+		if err := c.compress(&buf, payload); err != nil {
+			t.Fatalf("%s/%s: %v", compression, name, err)
+		}
+		got, err := decode(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s/%s: %v", compression, name, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%s/%s: payload doesn't round trip", compression, name)
+		}
+	}
+}
+
+func TestGzipCompressorRoundTrip(t *testing.T) {
+	for _, level := range []int{0, gzip.BestSpeed, gzip.BestCompression} {
+		testCompressorRoundTrip(t, CompressionGzip, level, gzipDecode)
+	}
+}
+
+func TestGzipCompressorStreamRoundTrip(t *testing.T) {
+	c, _ := newCompressor(CompressionGzip, 0)
+	payload := testPayloads()["entries"]
+
+	// Reuse pooled writers.
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		w := c.(streamCompressor).writer(&buf)
+		for p := payload; len(p) > 0; {
+			n := 1000
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := gzipDecode(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatal("payload doesn't round trip")
+		}
+	}
+}
+
+func TestSnappyCompressorRoundTrip(t *testing.T) {
+	testCompressorRoundTrip(t, CompressionSnappy, 0, snappyDecode)
+}
+
+func TestSnappyCompressorCompresses(t *testing.T) {
+	payload := testPayloads()["entries"]
+	if n := len(snappyEncode(nil, payload)); n > len(payload)/2 {
+		t.Fatalf("compressed %d bytes into %d", len(payload), n)
+	}
+}
+
+func TestNewCompressorErrors(t *testing.T) {
+	if _, err := newCompressor(CompressionSnappy, 1); err == nil {
+		t.Error("snappy accepted a compression level")
+	}
+	if _, err := newCompressor(CompressionGzip, 42); err == nil {
+		t.Error("gzip accepted an invalid compression level")
+	}
+	if _, err := newCompressor("lz4", 0); err == nil {
+		t.Error("unknown compression accepted")
+	}
+	if _, err := newCompressor(CompressionZstd, 0); (err == nil) != zstdSupported {
+		t.Errorf("zstd supported: %v, got error %v", zstdSupported, err)
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// Fields added to entries standing for several identical ones.
+	RepeatCountField          = "REPEAT_COUNT"
+	RepeatFirstTimestampField = "REPEAT_FIRST_TIMESTAMP"
+	RepeatLastTimestampField  = "REPEAT_LAST_TIMESTAMP"
+)
+
+// DedupConfig represents options to drive the behavior of a DedupStage.
+type DedupConfig struct {
+	Enabled bool
+
+	// Fields identifying the streams duplicates are looked for within.
+	Keys []string
+
+	// Field compared to detect duplicates.
+	Field string
+
+	// Duplicates are collapsed for at most this long.
+	Window time.Duration
+
+	// Only collapse consecutive duplicates, a different value emits the
+	// pending ones right away.
+	Consecutive bool
+}
+
+// NewDedupConfig creates a DedupConfig collapsing consecutive MESSAGE
+// duplicates of the same unit and process.
+func NewDedupConfig() DedupConfig {
+	return DedupConfig{
+		Keys:        []string{"_SYSTEMD_UNIT", "_PID"},
+		Field:       "MESSAGE",
+		Window:      10 * time.Second,
+		Consecutive: true,
+	}
+}
+
+// IsZero reports whether deduplication is disabled.
+func (c DedupConfig) IsZero() bool {
+	return !c.Enabled
+}
+
+// DedupStage collapses repeated entries into a single one, like syslog's "last
+// message repeated N times". The collapsed entry keeps the fields and the
+// cursor of the first one, and tells how many there were and when. The
+// forwarder doesn't persist the cursor past collapsed entries being held, even
+// if other entries are emitted meanwhile.
+type DedupStage struct {
+	keys        []string
+	field       string
+	window      time.Duration
+	consecutive bool
+	streams     map[string]map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	entry *sdjournal.JournalEntry
+	count uint64
+	first time.Time
+	last  uint64
+}
+
+// NewDedupStage creates a DedupStage as described by config.
+func NewDedupStage(config DedupConfig) (*DedupStage, error) {
+	return &DedupStage{
+		keys:        config.Keys,
+		field:       config.Field,
+		window:      config.Window,
+		consecutive: config.Consecutive,
+		streams:     make(map[string]map[string]*dedupEntry),
+	}, nil
+}
+
+func (s *DedupStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	value, ok := e.Fields[s.field]
+	if !ok {
+		emit(e)
+		return
+	}
+
+	key := streamKey(e, s.keys)
+	pending := s.streams[key]
+	if pending == nil {
+		pending = make(map[string]*dedupEntry)
+		s.streams[key] = pending
+	}
+
+	if d, ok := pending[value]; ok {
+		d.count++
+		d.last = e.RealtimeTimestamp
+		return
+	}
+
+	if s.consecutive {
+		s.emitPending(key, time.Time{}, emit)
+		pending = make(map[string]*dedupEntry)
+		s.streams[key] = pending
+	}
+
+	pending[value] = &dedupEntry{
+		entry: e,
+		count: 1,
+		first: time.Now(),
+		last:  e.RealtimeTimestamp,
+	}
+}
+
+func (s *DedupStage) held(fn func(cursor string)) {
+	for _, pending := range s.streams {
+		for _, d := range pending {
+			fn(d.entry.Cursor)
+		}
+	}
+}
+
+func (s *DedupStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+	keys := make([]string, 0, len(s.streams))
+	for key := range s.streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s.emitPending(key, now, emit)
+	}
+}
+
+// emitPending emits the entries of a stream collapsed for longer than the
+// window, or all of them if now is zero.
+func (s *DedupStage) emitPending(key string, now time.Time, emit func(*sdjournal.JournalEntry)) {
+	pending := s.streams[key]
+
+	var expired []*dedupEntry
+	for value, d := range pending {
+		if now.IsZero() || now.Sub(d.first) >= s.window {
+			expired = append(expired, d)
+			delete(pending, value)
+		}
+	}
+	if len(pending) == 0 {
+		delete(s.streams, key)
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].entry.RealtimeTimestamp < expired[j].entry.RealtimeTimestamp
+	})
+	for _, d := range expired {
+		if d.count > 1 {
+			d.entry.Fields[RepeatCountField] = strconv.FormatUint(d.count, 10)
+			d.entry.Fields[RepeatFirstTimestampField] = strconv.FormatUint(d.entry.RealtimeTimestamp, 10)
+			d.entry.Fields[RepeatLastTimestampField] = strconv.FormatUint(d.last, 10)
+		}
+		emit(d.entry)
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestDedupStage(t *testing.T, consecutive bool, window time.Duration) *DedupStage {
+	config := NewDedupConfig()
+	config.Enabled = true
+	config.Keys = []string{"_SYSTEMD_UNIT"}
+	config.Consecutive = consecutive
+	config.Window = window
+	s, err := NewDedupStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDedupStageConsecutiveHoldsCursor(t *testing.T) {
+	r := newCheckpointRecorder(newTestDedupStage(t, true, time.Minute))
+	r.process("a", "x")
+	r.process("b", "y")
+	r.process("a", "x")
+	r.process("a", "z")
+	r.flush(time.Time{})
+
+	r.assert(t,
+		checkpointed{"x", "c1", "c1"},
+		checkpointed{"z", "c4", "c1"},
+		checkpointed{"y", "c2", "c4"},
+	)
+}
+
+func TestDedupStageNonConsecutiveHoldsCursor(t *testing.T) {
+	window := 50 * time.Millisecond
+	r := newCheckpointRecorder(newTestDedupStage(t, false, window))
+	r.process("a", "x")
+	time.Sleep(2 * window)
+	r.process("a", "y")
+	r.process("a", "x")
+
+	// x expired, y is still held so the cursor can't go past it
+	r.flush(time.Now())
+	r.assert(t,
+		checkpointed{"x", "c1", "c1"},
+	)
+	if r.checkpointer.pipeline.stages[0].(*DedupStage).streams["a"]["x"] != nil {
+		t.Fatal("x is still pending")
+	}
+
+	r.flush(time.Time{})
+	r.assert(t,
+		checkpointed{"x", "c1", "c1"},
+		checkpointed{"y", "c2", "c3"},
+	)
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// Fields added to entries of docker containers.
+	DockerImageField          = "docker.image"
+	DockerImageDigestField    = "docker.image_digest"
+	DockerComposeProjectField = "docker.compose_project"
+	DockerComposeServiceField = "docker.compose_service"
+	DockerLabelsPrefix        = "docker.labels."
+
+	dockerComposeProjectLabel = "com.docker.compose.project"
+	dockerComposeServiceLabel = "com.docker.compose.service"
+)
+
+// DockerConfig represents options to drive the behavior of a DockerStage.
+type DockerConfig struct {
+	Enabled bool
+
+	// Unix socket the Docker Engine API listens on.
+	Socket string
+
+	// How long container metadata is cached for.
+	CacheTTL time.Duration
+
+	// Timeout of requests to the Docker Engine API.
+	Timeout time.Duration
+
+	// Invalidate cached metadata from the docker event stream.
+	Events bool
+}
+
+// NewDockerConfig creates a DockerConfig talking to the local engine.
+func NewDockerConfig() DockerConfig {
+	return DockerConfig{
+		Socket:   "/var/run/docker.sock",
+		CacheTTL: 5 * time.Minute,
+		Timeout:  5 * time.Second,
+		Events:   true,
+	}
+}
+
+// IsZero reports whether docker enrichment is disabled.
+func (c DockerConfig) IsZero() bool {
+	return !c.Enabled
+}
+
+// DockerStage adds image and label information to entries written by docker
+// containers through the journald log driver, querying the Docker Engine API
+// for their CONTAINER_ID. Containers are inspected in the background: entries
+// logged before a container is known are left as they are.
+type DockerStage struct {
+	api        *metadataClient
+	containers *metadataCache
+}
+
+type dockerContainer struct {
+	ID     string `json:"Id"`
+	Image  string `json:"Image"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	digest string
+}
+
+type dockerImage struct {
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
+
+// NewDockerStage creates a DockerStage as described by config.
+func NewDockerStage(config DockerConfig) (*DockerStage, error) {
+	transport, err := newHTTPTransport(newMetadataHTTPConfig(config.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", config.Socket)
+	}
+	transport.Proxy = nil
+
+	// The host is irrelevant, every request goes through the unix socket.
+	s := &DockerStage{
+		api: newMetadataClient("http://docker", transport, config.Timeout),
+	}
+	s.containers = newMetadataCache(config.CacheTTL, s.inspect)
+
+	if config.Events {
+		filters := url.QueryEscape(`{"type":["container"]}`)
+		go s.api.watch("/events?filters="+filters, s.handleEvent)
+	}
+
+	return s, nil
+}
+
+func (s *DockerStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	id := e.Fields["CONTAINER_ID"]
+	if id == "" {
+		emit(e)
+		return
+	}
+
+	if c, _ := s.containers.get(id).(*dockerContainer); c != nil {
+		e.Fields[DockerImageField] = c.Config.Image
+		if c.digest != "" {
+			e.Fields[DockerImageDigestField] = c.digest
+		}
+		if project, ok := c.Config.Labels[dockerComposeProjectLabel]; ok {
+			e.Fields[DockerComposeProjectField] = project
+		}
+		if service, ok := c.Config.Labels[dockerComposeServiceLabel]; ok {
+			e.Fields[DockerComposeServiceField] = service
+		}
+		for k, v := range c.Config.Labels {
+			e.Fields[DockerLabelsPrefix+dedot(k)] = v
+		}
+	}
+
+	emit(e)
+}
+
+func (s *DockerStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// Close stops inspecting containers and watching the event stream.
+func (s *DockerStage) Close() error {
+	s.containers.Close()
+	return s.api.Close()
+}
+
+// inspect gets the metadata of a container.
+func (s *DockerStage) inspect(id string) (interface{}, error) {
+	c := &dockerContainer{}
+	if err := s.api.get("/containers/"+url.PathEscape(id)+"/json", c); err != nil {
+		return nil, err
+	}
+
+	image := &dockerImage{}
+	if err := s.api.get("/images/"+url.PathEscape(c.Image)+"/json", image); err == nil && len(image.RepoDigests) > 0 {
+		c.digest = image.RepoDigests[0]
+		if i := strings.LastIndex(c.digest, "@"); i >= 0 {
+			c.digest = c.digest[i+1:]
+		}
+	}
+
+	return c, nil
+}
+
+// handleEvent invalidates a container as it changes. Containers are cached by
+// the (usually short) ID found in the journal.
+func (s *DockerStage) handleEvent(decoder *json.Decoder) error {
+	var event dockerEvent
+	if err := decoder.Decode(&event); err != nil {
+		return err
+	}
+	if event.Actor.ID == "" {
+		return nil
+	}
+
+	switch event.Action {
+	case "create", "start":
+		// New containers are looked up on demand.
+	default:
+		s.containers.invalidate(func(id string) bool {
+			return strings.HasPrefix(event.Actor.ID, id)
+		})
+	}
+	return nil
+}
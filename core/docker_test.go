@@ -0,0 +1,173 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const testDockerContainerID = "0123456789ab"
+
+// testDockerEngine stands in for the Docker Engine API on a unix socket.
+type testDockerEngine struct {
+	*httptest.Server
+	socket string
+	dir    string
+
+	// Image of the container, changed by tests.
+	imagec chan string
+	eventc chan *dockerEvent
+}
+
+func newTestDockerEngine(t *testing.T) *testDockerEngine {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDockerEngine{
+		socket: filepath.Join(dir, "docker.sock"),
+		dir:    dir,
+		imagec: make(chan string, 1),
+		eventc: make(chan *dockerEvent),
+	}
+	d.imagec <- "nginx:1"
+
+	l, err := net.Listen("unix", d.socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	d.Server = httptest.NewUnstartedServer(http.HandlerFunc(d.serve))
+	d.Server.Listener = l
+	d.Server.Start()
+	return d
+}
+
+func (d *testDockerEngine) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/containers/" + testDockerContainerID + "/json":
+		image := <-d.imagec
+		d.imagec <- image
+		c := &dockerContainer{ID: testDockerContainerID + "cdef", Image: "sha256:1"}
+		c.Config.Image = image
+		c.Config.Labels = map[string]string{dockerComposeProjectLabel: "shop"}
+		json.NewEncoder(w).Encode(c)
+	case "/images/sha256:1/json":
+		json.NewEncoder(w).Encode(&dockerImage{RepoDigests: []string{"nginx@sha256:abc"}})
+	case "/events":
+		encoder := json.NewEncoder(w)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-d.eventc:
+				encoder.Encode(event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *testDockerEngine) setImage(image string) {
+	<-d.imagec
+	d.imagec <- image
+}
+
+func (d *testDockerEngine) Close() {
+	d.Server.Close()
+	os.RemoveAll(d.dir)
+}
+
+func newTestDockerStage(t *testing.T, socket string) *DockerStage {
+	config := NewDockerConfig()
+	config.Enabled = true
+	config.Socket = socket
+	s, err := NewDockerStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestDockerEntry() *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{
+		Fields: map[string]string{"CONTAINER_ID": testDockerContainerID},
+	}
+}
+
+func TestDockerStageInspectsContainers(t *testing.T) {
+	d := newTestDockerEngine(t)
+	defer d.Close()
+	s := newTestDockerStage(t, d.socket)
+	defer s.Close()
+
+	e := processUntil(t, s, newTestDockerEntry, DockerImageField)
+	want := map[string]string{
+		DockerImageField:                                  "nginx:1",
+		DockerImageDigestField:                            "sha256:abc",
+		DockerComposeProjectField:                         "shop",
+		DockerLabelsPrefix + "com_docker_compose_project": "shop",
+	}
+	for k, v := range want {
+		if e.Fields[k] != v {
+			t.Errorf("%s is %q, want %q", k, e.Fields[k], v)
+		}
+	}
+}
+
+func TestDockerStageInvalidatesFromEvents(t *testing.T) {
+	d := newTestDockerEngine(t)
+	defer d.Close()
+	s := newTestDockerStage(t, d.socket)
+
+	processUntil(t, s, newTestDockerEntry, DockerImageField)
+
+	// The event carries the full ID, the journal the short one.
+	d.setImage("nginx:2")
+	event := &dockerEvent{Type: "container", Action: "update"}
+	event.Actor.ID = testDockerContainerID + "cdef"
+	d.eventc <- event
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got *sdjournal.JournalEntry
+		s.Process(newTestDockerEntry(), func(e *sdjournal.JournalEntry) { got = e })
+		if got.Fields[DockerImageField] == "nginx:2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("container wasn't inspected again, got %v", got.Fields)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Closing the stage ends the event stream, letting the server shut down.
+	s.Close()
+}
+
+func TestDockerStageWithoutEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newTestDockerStage(t, filepath.Join(dir, "docker.sock"))
+	defer s.Close()
+
+	var got *sdjournal.JournalEntry
+	s.Process(newTestDockerEntry(), func(e *sdjournal.JournalEntry) { got = e })
+	if got == nil || len(got.Fields) != 1 {
+		t.Fatalf("entry wasn't emitted as is: %v", got)
+	}
+}
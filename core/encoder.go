@@ -0,0 +1,154 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Encodings of entries.
+const (
+	EncodingJSON     = "json"
+	EncodingMsgpack  = "msgpack"
+	EncodingCBOR     = "cbor"
+	EncodingProtobuf = "protobuf"
+)
+
+// Encoder serializes entries. The returned slices are only valid until the
+// next call.
+type Encoder interface {
+	MarshalOne(e *sdjournal.JournalEntry) []byte
+	MarshalAll(ea []*sdjournal.JournalEntry) []byte
+	// ContentType returns the media type of encoded entries.
+	ContentType() string
+}
+
+// NewEncoder creates an Encoder for encoding configured with
+// DefaultMarshallerConfig.
+func NewEncoder(encoding string) (Encoder, error) {
+	return NewEncoderWithConfig(encoding, DefaultMarshallerConfig)
+}
+
+// NewEncoderWithConfig creates an Encoder for encoding configured with
+// config. Binary encodings honor the field types, sorting and header field
+// names of config, profiles and nesting are only supported by JSON.
+func NewEncoderWithConfig(encoding string, config MarshallerConfig) (Encoder, error) {
+	switch encoding {
+	case EncodingJSON:
+		return NewJournalEntryMarshallerWithConfig(config), nil
+	case EncodingMsgpack:
+		return NewMsgpackEncoder(config), nil
+	case EncodingCBOR:
+		return NewCBOREncoder(config), nil
+	case EncodingProtobuf:
+		return NewProtobufEncoder(config), nil
+	}
+	return nil, fmt.Errorf("unknown encoding: %s", encoding)
+}
+
+// ParseEncoding checks name is a known encoding.
+func ParseEncoding(name string) (string, error) {
+	switch name {
+	case EncodingJSON, EncodingMsgpack, EncodingCBOR, EncodingProtobuf:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown encoding: %s", name)
+}
+
+// mapWriter writes the primitives of self-describing binary encodings.
+type mapWriter interface {
+	writeMapHeader(n int)
+	writeString(s string)
+	writeBytes(b string)
+	writeUint(v uint64)
+	writeInt(v int64)
+	writeFloat(v float64)
+	writeBool(v bool)
+}
+
+// entryMapper writes entries as maps of field names to values through a
+// mapWriter, typing values as the marshaller config says.
+type entryMapper struct {
+	config MarshallerConfig
+	types  map[string]FieldType
+	keys   []string
+}
+
+func newEntryMapper(config MarshallerConfig) entryMapper {
+	config = config.withDefaults()
+	return entryMapper{config: config, types: config.fieldTypes()}
+}
+
+func (m *entryMapper) writeEntry(w mapWriter, e *sdjournal.JournalEntry) {
+	m.keys = m.keys[:0]
+	for key := range e.Fields {
+		m.keys = append(m.keys, key)
+	}
+	if m.config.SortKeys {
+		sort.Strings(m.keys)
+	}
+
+	n := len(m.keys)
+	for _, name := range []string{m.config.CursorField, m.config.RealtimeField, m.config.MonotonicField} {
+		if name != OmitField {
+			n++
+		}
+	}
+	w.writeMapHeader(n)
+
+	if m.config.CursorField != OmitField {
+		w.writeString(m.config.CursorField)
+		w.writeString(e.Cursor)
+	}
+	if m.config.RealtimeField != OmitField {
+		w.writeString(m.config.RealtimeField)
+		m.writeTimestamp(w, "__REALTIME_TIMESTAMP", e.RealtimeTimestamp)
+	}
+	if m.config.MonotonicField != OmitField {
+		w.writeString(m.config.MonotonicField)
+		m.writeTimestamp(w, "__MONOTONIC_TIMESTAMP", e.MonotonicTimestamp)
+	}
+	for _, key := range m.keys {
+		w.writeString(key)
+		m.writeValue(w, key, e.Fields[key])
+	}
+}
+
+func (m *entryMapper) writeTimestamp(w mapWriter, key string, usec uint64) {
+	if m.types[key] == FieldTime {
+		w.writeString(FormatUsec(usec))
+		return
+	}
+	w.writeUint(usec)
+}
+
+// writeValue writes value according to the type of the field, falling back to
+// a string, or bytes if it isn't valid UTF-8.
+func (m *entryMapper) writeValue(w mapWriter, key string, value string) {
+	switch m.types[key] {
+	case FieldInt:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			w.writeInt(v)
+			return
+		}
+	case FieldFloat:
+		if v, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(v, 0) && !math.IsNaN(v) {
+			w.writeFloat(v)
+			return
+		}
+	case FieldBool:
+		if v, err := strconv.ParseBool(value); err == nil {
+			w.writeBool(v)
+			return
+		}
+	}
+	if !utf8.ValidString(value) {
+		w.writeBytes(value)
+		return
+	}
+	w.writeString(value)
+}
@@ -0,0 +1,517 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Values decoded out of the self-describing encodings. Binary values decode
+// as []byte, so that they can be told apart from strings, and integers as
+// uint64 unless they're negative, however they were encoded.
+
+type testDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *testDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = fmt.Errorf("truncated, %d bytes left, %d wanted", len(d.buf), n)
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *testDecoder) uint(n int) uint64 {
+	b := d.next(n)
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// decodeMsgpack decodes the MessagePack data item at the start of d.
+func (d *testDecoder) decodeMsgpack() interface{} {
+	b := d.next(1)
+	if b == nil {
+		return nil
+	}
+	switch c := b[0]; {
+	case c < 0x80:
+		return uint64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	case c&0xf0 == 0x80:
+		return d.msgpackMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.msgpackArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return string(d.next(int(c & 0x1f)))
+	}
+	switch c := b[0]; c {
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	case 0xc4, 0xc5, 0xc6:
+		return append([]byte{}, d.next(int(d.uint(1<<(c-0xc4))))...)
+	case 0xcb:
+		return math.Float64frombits(d.uint(8))
+	case 0xcf:
+		return d.uint(8)
+	case 0xd3:
+		return int64(d.uint(8))
+	case 0xd9, 0xda, 0xdb:
+		return string(d.next(int(d.uint(1 << (c - 0xd9)))))
+	case 0xdc, 0xdd:
+		return d.msgpackArray(int(d.uint(2 << (c - 0xdc))))
+	case 0xde, 0xdf:
+		return d.msgpackMap(int(d.uint(2 << (c - 0xde))))
+	}
+	d.err = fmt.Errorf("unexpected msgpack byte %#x", b[0])
+	return nil
+}
+
+func (d *testDecoder) msgpackArray(n int) interface{} {
+	a := []interface{}{}
+	for i := 0; i < n && d.err == nil; i++ {
+		a = append(a, d.decodeMsgpack())
+	}
+	return a
+}
+
+func (d *testDecoder) msgpackMap(n int) interface{} {
+	m := map[string]interface{}{}
+	for i := 0; i < n && d.err == nil; i++ {
+		key, ok := d.decodeMsgpack().(string)
+		if !ok {
+			d.err = fmt.Errorf("map key isn't a string")
+			return nil
+		}
+		m[key] = d.decodeMsgpack()
+	}
+	return m
+}
+
+// decodeCBOR decodes the CBOR data item at the start of d.
+func (d *testDecoder) decodeCBOR() interface{} {
+	b := d.next(1)
+	if b == nil {
+		return nil
+	}
+	major, info := b[0]&0xe0, b[0]&0x1f
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false
+		case 21:
+			return true
+		case 27:
+			return math.Float64frombits(d.uint(8))
+		}
+		d.err = fmt.Errorf("unexpected cbor simple value %d", info)
+		return nil
+	}
+
+	n := uint64(info)
+	switch {
+	case info >= 24 && info <= 27:
+		n = d.uint(1 << (info - 24))
+	case info > 27:
+		d.err = fmt.Errorf("unexpected cbor additional info %d", info)
+		return nil
+	}
+	switch major {
+	case cborUint:
+		return n
+	case cborNegint:
+		return -1 - int64(n)
+	case cborBytes:
+		return append([]byte{}, d.next(int(n))...)
+	case cborText:
+		return string(d.next(int(n)))
+	case cborArray:
+		a := []interface{}{}
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			a = append(a, d.decodeCBOR())
+		}
+		return a
+	case cborMap:
+		m := map[string]interface{}{}
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key, ok := d.decodeCBOR().(string)
+			if !ok {
+				d.err = fmt.Errorf("map key isn't a string")
+				return nil
+			}
+			m[key] = d.decodeCBOR()
+		}
+		return m
+	}
+	return nil
+}
+
+func decodeAll(t *testing.T, encoding string, data []byte) interface{} {
+	d := &testDecoder{buf: data}
+	var v interface{}
+	switch encoding {
+	case EncodingMsgpack:
+		v = d.decodeMsgpack()
+	case EncodingCBOR:
+		v = d.decodeCBOR()
+	}
+	if d.err != nil {
+		t.Fatalf("%s: %v", encoding, d.err)
+	}
+	if len(d.buf) > 0 {
+		t.Fatalf("%s: %d trailing bytes", encoding, len(d.buf))
+	}
+	return normalizeInts(v)
+}
+
+func normalizeInts(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		if v >= 0 {
+			return uint64(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeInts(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeInts(v[k])
+		}
+	}
+	return v
+}
+
+// newTestEncoderEntry creates an entry exercising every length and value
+// encoding: long strings and maps, binary values and timestamps which don't
+// fit in 63 bits.
+func newTestEncoderEntry() *sdjournal.JournalEntry {
+	e := &sdjournal.JournalEntry{
+		Cursor:             "s=1;i=2",
+		RealtimeTimestamp:  math.MaxUint64,
+		MonotonicTimestamp: 1 << 63,
+		Fields: map[string]string{
+			"MESSAGE":   "hello",
+			"PRIORITY":  "6",
+			"ERRNO":     "-2",
+			"_PID":      "1234567",
+			"BINARY":    "\x00\xff\xfe\n",
+			"STR8":      strings.Repeat("a", 200),
+			"STR16":     strings.Repeat("b", 300),
+			"STR32":     strings.Repeat("c", 70000),
+			"BIN16":     strings.Repeat("\xff", 300),
+			"BIN32":     strings.Repeat("\xff", 70000),
+			"EMPTY":     "",
+			"MULTILINE": "a\nb\n",
+		},
+	}
+	for i := 0; i < 300; i++ {
+		e.Fields[fmt.Sprintf("FIELD_%d", i)] = fmt.Sprint(i)
+	}
+	return e
+}
+
+// expectedEntry returns what e decodes to, given the types of its fields.
+func expectedEntry(e *sdjournal.JournalEntry, types map[string]FieldType) map[string]interface{} {
+	m := map[string]interface{}{
+		"__CURSOR":              e.Cursor,
+		"__REALTIME_TIMESTAMP":  e.RealtimeTimestamp,
+		"__MONOTONIC_TIMESTAMP": e.MonotonicTimestamp,
+	}
+	for key, value := range e.Fields {
+		switch {
+		case types[key] == FieldInt && value[0] == '-':
+			var v int64
+			fmt.Sscan(value, &v)
+			m[key] = v
+		case types[key] == FieldInt:
+			var v uint64
+			fmt.Sscan(value, &v)
+			m[key] = v
+		case !utf8.ValidString(value):
+			m[key] = []byte(value)
+		default:
+			m[key] = value
+		}
+	}
+	return m
+}
+
+func TestBinaryEncodersRoundTrip(t *testing.T) {
+	e := newTestEncoderEntry()
+	for _, typed := range []bool{false, true} {
+		config := NewMarshallerConfig()
+		config.TypedFields = typed
+		types := config.fieldTypes()
+		want := expectedEntry(e, types)
+
+		for _, encoding := range []string{EncodingMsgpack, EncodingCBOR} {
+			encoder, err := NewEncoderWithConfig(encoding, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := decodeAll(t, encoding, encoder.MarshalOne(e))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s (typed %v): entry doesn't round trip", encoding, typed)
+			}
+
+			got = decodeAll(t, encoding, encoder.MarshalAll([]*sdjournal.JournalEntry{e, e}))
+			if !reflect.DeepEqual(got, []interface{}{want, want}) {
+				t.Errorf("%s (typed %v): batch doesn't round trip", encoding, typed)
+			}
+		}
+	}
+}
+
+func TestBinaryEncodersLargeMaps(t *testing.T) {
+	e := &sdjournal.JournalEntry{Fields: map[string]string{}}
+	for i := 0; i < 70000; i++ {
+		e.Fields[fmt.Sprintf("F%d", i)] = ""
+	}
+	config := NewMarshallerConfig()
+	config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+
+	for _, encoding := range []string{EncodingMsgpack, EncodingCBOR} {
+		encoder, _ := NewEncoderWithConfig(encoding, config)
+		got, ok := decodeAll(t, encoding, encoder.MarshalOne(e)).(map[string]interface{})
+		if !ok || len(got) != len(e.Fields) {
+			t.Errorf("%s: got %d fields, want %d", encoding, len(got), len(e.Fields))
+		}
+	}
+}
+
+func TestBinaryEncodersTypedValues(t *testing.T) {
+	e := &sdjournal.JournalEntry{
+		RealtimeTimestamp: 1483585445000007,
+		Fields: map[string]string{
+			"INT":       "-100000",
+			"SMALL_INT": "-5",
+			"FLOAT":     "0.25",
+			"BOOL":      "true",
+			"BAD_INT":   "n/a",
+		},
+	}
+	config := NewMarshallerConfig()
+	config.CursorField, config.MonotonicField = OmitField, OmitField
+	config.FieldTypes = map[string]FieldType{
+		"__REALTIME_TIMESTAMP": FieldTime,
+		"INT":                  FieldInt,
+		"SMALL_INT":            FieldInt,
+		"FLOAT":                FieldFloat,
+		"BOOL":                 FieldBool,
+		"BAD_INT":              FieldInt,
+	}
+	want := map[string]interface{}{
+		"__REALTIME_TIMESTAMP": "2017-01-05T03:04:05.000007Z",
+		"INT":                  int64(-100000),
+		"SMALL_INT":            int64(-5),
+		"FLOAT":                0.25,
+		"BOOL":                 true,
+		"BAD_INT":              "n/a",
+	}
+
+	for _, encoding := range []string{EncodingMsgpack, EncodingCBOR} {
+		encoder, _ := NewEncoderWithConfig(encoding, config)
+		if got := decodeAll(t, encoding, encoder.MarshalOne(e)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", encoding, got, want)
+		}
+	}
+}
+
+// TestBinaryEncodersGolden checks the exact bytes of a small entry against
+// what the reference implementations produce.
+func TestBinaryEncodersGolden(t *testing.T) {
+	e := &sdjournal.JournalEntry{
+		Cursor:             "c",
+		RealtimeTimestamp:  1 << 63,
+		MonotonicTimestamp: 1,
+		Fields:             map[string]string{"M": "\xff"},
+	}
+	config := NewMarshallerConfig()
+	config.CursorField, config.RealtimeField, config.MonotonicField = "c", "r", "m"
+
+	tests := []struct {
+		encoding string
+		want     []byte
+	}{
+		{EncodingMsgpack, []byte{
+			0x84,
+			0xa1, 'c', 0xa1, 'c',
+			0xa1, 'r', 0xcf, 0x80, 0, 0, 0, 0, 0, 0, 0,
+			0xa1, 'm', 0x01,
+			0xa1, 'M', 0xc4, 0x01, 0xff,
+		}},
+		{EncodingCBOR, []byte{
+			0xa4,
+			0x61, 'c', 0x61, 'c',
+			0x61, 'r', 0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0,
+			0x61, 'm', 0x01,
+			0x61, 'M', 0x41, 0xff,
+		}},
+		{EncodingProtobuf, []byte{
+			0x0a, 0x01, 'c',
+			0x10, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01,
+			0x18, 0x01,
+			0x22, 0x06, 0x0a, 0x01, 'M', 0x12, 0x01, 0xff,
+		}},
+	}
+
+	for _, test := range tests {
+		encoder, _ := NewEncoderWithConfig(test.encoding, config)
+		if got := encoder.MarshalOne(e); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got % x, want % x", test.encoding, got, test.want)
+		}
+	}
+}
+
+// Protobuf
+
+type testProtobufEntry struct {
+	Cursor             string
+	RealtimeTimestamp  uint64
+	MonotonicTimestamp uint64
+	Fields             map[string]string
+}
+
+func (d *testDecoder) varint() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 70; shift += 7 {
+		b := d.next(1)
+		if b == nil {
+			return 0
+		}
+		v |= uint64(b[0]&0x7f) << shift
+		if b[0] < 0x80 {
+			return v
+		}
+	}
+	d.err = fmt.Errorf("varint overflow")
+	return 0
+}
+
+// protobufFields calls f with the tag and contents of every field of the
+// message in d, varints being decoded into v.
+func (d *testDecoder) protobufFields(f func(tag uint64, v uint64, b []byte)) {
+	for len(d.buf) > 0 && d.err == nil {
+		tag := d.varint()
+		switch tag & 7 {
+		case 0:
+			f(tag, d.varint(), nil)
+		case 2:
+			f(tag, 0, d.next(int(d.varint())))
+		default:
+			d.err = fmt.Errorf("unexpected wire type %d", tag&7)
+		}
+	}
+}
+
+func decodeProtobufEntry(b []byte) (*testProtobufEntry, error) {
+	e := &testProtobufEntry{Fields: map[string]string{}}
+	d := &testDecoder{buf: b}
+	d.protobufFields(func(tag uint64, v uint64, b []byte) {
+		switch tag {
+		case protobufCursorTag:
+			e.Cursor = string(b)
+		case protobufRealtimeTag:
+			e.RealtimeTimestamp = v
+		case protobufMonotonicTag:
+			e.MonotonicTimestamp = v
+		case protobufFieldsTag:
+			var key, value string
+			fd := &testDecoder{buf: b}
+			fd.protobufFields(func(tag uint64, v uint64, b []byte) {
+				switch tag {
+				case protobufKeyTag:
+					key = string(b)
+				case protobufValueTag:
+					value = string(b)
+				}
+			})
+			if fd.err != nil {
+				d.err = fd.err
+			}
+			e.Fields[key] = value
+		default:
+			d.err = fmt.Errorf("unexpected tag %#x", tag)
+		}
+	})
+	return e, d.err
+}
+
+func decodeProtobufBatch(b []byte) ([]*testProtobufEntry, error) {
+	var entries []*testProtobufEntry
+	d := &testDecoder{buf: b}
+	d.protobufFields(func(tag uint64, v uint64, b []byte) {
+		if tag != protobufEntriesTag {
+			d.err = fmt.Errorf("unexpected tag %#x", tag)
+			return
+		}
+		e, err := decodeProtobufEntry(b)
+		if err != nil {
+			d.err = err
+		}
+		entries = append(entries, e)
+	})
+	return entries, d.err
+}
+
+func TestProtobufEncoderRoundTrip(t *testing.T) {
+	e := newTestEncoderEntry()
+	want := &testProtobufEntry{
+		Cursor:             e.Cursor,
+		RealtimeTimestamp:  e.RealtimeTimestamp,
+		MonotonicTimestamp: e.MonotonicTimestamp,
+		Fields:             e.Fields,
+	}
+	encoder := NewProtobufEncoder(NewMarshallerConfig())
+
+	got, err := decodeProtobufEntry(encoder.MarshalOne(e))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("entry doesn't round trip")
+	}
+
+	batch, err := decodeProtobufBatch(encoder.MarshalAll([]*sdjournal.JournalEntry{e, e}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(batch, []*testProtobufEntry{want, want}) {
+		t.Error("batch doesn't round trip")
+	}
+}
+
+func TestProtobufEncoderOmitsDefaults(t *testing.T) {
+	e := &sdjournal.JournalEntry{Fields: map[string]string{"EMPTY": ""}}
+	got := NewProtobufEncoder(NewMarshallerConfig()).MarshalOne(e)
+	if want := []byte{0x22, 0x09, 0x0a, 0x05, 'E', 'M', 'P', 'T', 'Y', 0x12, 0x00}; !bytes.Equal(got, want) {
+		t.Fatalf("got % x, want % x", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// EntryReader reads journal entries out of a serialized stream.
+type EntryReader interface {
+	// ReadEntry returns the next entry in the stream or io.EOF once there are
+	// no more entries.
+	ReadEntry() (*sdjournal.JournalEntry, error)
+
+	// Offset returns the number of bytes consumed from the stream so far.
+	Offset() int64
+}
+
+// countingReader keeps track of the number of bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (c *countingReader) ReadSlice(delim byte) ([]byte, error) {
+	line, err := c.r.ReadSlice(delim)
+	c.n += int64(len(line))
+	return line, err
+}
+
+func (c *countingReader) ReadFull(buf []byte) error {
+	n, err := io.ReadFull(c.r, buf)
+	c.n += int64(n)
+	return err
+}
+
+// readLine reads a whole line, without the trailing newline, even if it
+// doesn't fit in the underlying buffer.
+func (c *countingReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := c.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, io.ErrUnexpectedEOF
+			}
+			return line, err
+		}
+		return line[:len(line)-1], nil
+	}
+}
+
+// ExportReader reads entries serialized in the systemd Journal Export Format,
+// as produced by `journalctl -o export` or sent by systemd-journal-upload.
+//
+// See https://www.freedesktop.org/wiki/Software/systemd/export/
+type ExportReader struct {
+	r *countingReader
+}
+
+// NewExportReader creates an ExportReader reading from r.
+func NewExportReader(r io.Reader) *ExportReader {
+	return &ExportReader{&countingReader{r: bufio.NewReader(r)}}
+}
+
+func (er *ExportReader) Offset() int64 {
+	return er.r.n
+}
+
+func (er *ExportReader) ReadEntry() (*sdjournal.JournalEntry, error) {
+	var e *sdjournal.JournalEntry
+	for {
+		line, err := er.r.readLine()
+		if err != nil {
+			if err == io.EOF && e != nil {
+				return e, nil
+			}
+			return nil, err
+		}
+
+		// An empty line terminates the entry, skip extra ones between entries.
+		if len(line) == 0 {
+			if e != nil {
+				return e, nil
+			}
+			continue
+		}
+
+		if e == nil {
+			e = &sdjournal.JournalEntry{Fields: make(map[string]string)}
+		}
+
+		var key, value string
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			key, value = string(line[:i]), string(line[i+1:])
+		} else {
+			// Binary safe field: the name is followed by a little endian
+			// 64bit size, the data and a newline.
+			key = string(line)
+			var size [8]byte
+			if err := er.r.ReadFull(size[:]); err != nil {
+				return nil, unexpected(err)
+			}
+			n := binary.LittleEndian.Uint64(size[:])
+			if n > maxFieldSize {
+				return nil, fmt.Errorf("field %s too large: %d bytes", key, n)
+			}
+			data := make([]byte, n+1)
+			if err := er.r.ReadFull(data); err != nil {
+				return nil, unexpected(err)
+			}
+			if data[n] != '\n' {
+				return nil, fmt.Errorf("field %s not terminated by newline", key)
+			}
+			value = string(data[:n])
+		}
+
+		if err := setEntryField(e, key, value); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxFieldSize bounds the size of a single binary field to avoid allocating
+// arbitrary amounts of memory on corrupted streams.
+const maxFieldSize = 64 * 1024 * 1024
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// setEntryField stores a field, routing the special address fields to their
+// dedicated JournalEntry members.
+func setEntryField(e *sdjournal.JournalEntry, key, value string) error {
+	var err error
+	switch key {
+	case "__CURSOR":
+		e.Cursor = value
+	case "__REALTIME_TIMESTAMP":
+		e.RealtimeTimestamp, err = strconv.ParseUint(value, 10, 64)
+	case "__MONOTONIC_TIMESTAMP":
+		e.MonotonicTimestamp, err = strconv.ParseUint(value, 10, 64)
+	default:
+		// Fields can appear multiple times, keep the first value like
+		// sd_journal_get_data does.
+		if _, ok := e.Fields[key]; !ok {
+			e.Fields[key] = value
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+// JSONReader reads entries serialized one per line as produced by
+// `journalctl -o json`.
+type JSONReader struct {
+	r *countingReader
+}
+
+// NewJSONReader creates a JSONReader reading from r.
+func NewJSONReader(r io.Reader) *JSONReader {
+	return &JSONReader{&countingReader{r: bufio.NewReader(r)}}
+}
+
+func (jr *JSONReader) Offset() int64 {
+	return jr.r.n
+}
+
+func (jr *JSONReader) ReadEntry() (*sdjournal.JournalEntry, error) {
+	for {
+		line, err := jr.r.readLine()
+		if err == io.ErrUnexpectedEOF {
+			// The last line is allowed to lack the newline.
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, err
+		}
+
+		e := &sdjournal.JournalEntry{Fields: make(map[string]string, len(fields))}
+		for key, raw := range fields {
+			value, err := jsonFieldValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", key, err)
+			}
+			if raw == nil {
+				continue
+			}
+			if err := setEntryField(e, key, value); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+}
+
+// jsonFieldValue decodes a field value as encoded by journalctl: a string, an
+// array of bytes for binary data or an array of any of them if the field was
+// present more than once (only the first one is kept).
+func jsonFieldValue(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "", nil
+		}
+		if _, ok := v[0].(float64); !ok {
+			return jsonFieldValue(v[0])
+		}
+		data := make([]byte, len(v))
+		for i, b := range v {
+			f, ok := b.(float64)
+			if !ok || f < 0 || f > 255 {
+				return "", errors.New("invalid byte array")
+			}
+			data[i] = byte(f)
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("unexpected value %v", raw)
+	}
+}
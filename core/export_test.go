@@ -0,0 +1,158 @@
+package core
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// exportBinaryField serializes a field in the binary safe form of the Journal
+// Export Format.
+func exportBinaryField(key, value string) string {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	return key + "\n" + string(size[:]) + value + "\n"
+}
+
+func readEntries(t *testing.T, r EntryReader) []*sdjournal.JournalEntry {
+	var entries []*sdjournal.JournalEntry
+	for {
+		e, err := r.ReadEntry()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestExportReader(t *testing.T) {
+	stream := "__CURSOR=s=1\n" +
+		"__REALTIME_TIMESTAMP=1500000000000000\n" +
+		"__MONOTONIC_TIMESTAMP=42\n" +
+		"MESSAGE=first\n" +
+		"MESSAGE=duplicate\n" +
+		"\n\n" +
+		"__CURSOR=s=2\n" +
+		exportBinaryField("MESSAGE", "multi\nline") +
+		exportBinaryField("DATA", "\x00\xff\xfe=") +
+		"\n"
+
+	entries := readEntries(t, NewExportReader(strings.NewReader(stream)))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+
+	e := entries[0]
+	if e.Cursor != "s=1" || e.RealtimeTimestamp != 1500000000000000 || e.MonotonicTimestamp != 42 {
+		t.Fatalf("got %+v", e)
+	}
+	if e.Fields["MESSAGE"] != "first" {
+		t.Fatalf("MESSAGE is %q", e.Fields["MESSAGE"])
+	}
+
+	e = entries[1]
+	if e.Cursor != "s=2" || e.Fields["MESSAGE"] != "multi\nline" || e.Fields["DATA"] != "\x00\xff\xfe=" {
+		t.Fatalf("got %+v", e)
+	}
+}
+
+func TestExportReaderLastEntryWithoutNewline(t *testing.T) {
+	entries := readEntries(t, NewExportReader(strings.NewReader("MESSAGE=a\n\nMESSAGE=b\n")))
+	if len(entries) != 2 || entries[1].Fields["MESSAGE"] != "b" {
+		t.Fatalf("got %v", entries)
+	}
+}
+
+func TestExportReaderTruncated(t *testing.T) {
+	binaryField := exportBinaryField("MESSAGE", "multi\nline")
+	for _, stream := range []string{
+		"MESSAGE=a\n\nMESSAGE=b",
+		"MESSAGE\n\x0a\x00",
+		binaryField[:len(binaryField)-4],
+		binaryField[:len(binaryField)-1],
+	} {
+		r := NewExportReader(strings.NewReader(stream))
+		var err error
+		for err == nil {
+			_, err = r.ReadEntry()
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%q: got %v", stream, err)
+		}
+	}
+}
+
+func TestExportReaderInvalid(t *testing.T) {
+	for _, stream := range []string{
+		"__REALTIME_TIMESTAMP=yesterday\n\n",
+		"MESSAGE\n\x01\x00\x00\x00\x00\x00\x00\x00ab\n\n",
+		"MESSAGE\n\xff\xff\xff\xff\xff\xff\xff\xff",
+	} {
+		if _, err := NewExportReader(strings.NewReader(stream)).ReadEntry(); err == nil || err == io.EOF {
+			t.Errorf("%q: got %v", stream, err)
+		}
+	}
+}
+
+func TestExportReaderOffset(t *testing.T) {
+	stream := "MESSAGE=a\n\n" + exportBinaryField("MESSAGE", "b") + "\n"
+	r := NewExportReader(strings.NewReader(stream))
+	if _, err := r.ReadEntry(); err != nil || r.Offset() != int64(len("MESSAGE=a\n\n")) {
+		t.Fatalf("offset is %d after the first entry, %v", r.Offset(), err)
+	}
+	if _, err := r.ReadEntry(); err != nil || r.Offset() != int64(len(stream)) {
+		t.Fatalf("offset is %d after the second entry, %v", r.Offset(), err)
+	}
+}
+
+func TestJSONReader(t *testing.T) {
+	stream := `{"__CURSOR":"s=1","__REALTIME_TIMESTAMP":"1500000000000000","MESSAGE":"multi\nline"}` + "\n" +
+		"\n" +
+		`{"__CURSOR":"s=2","MESSAGE":[0,255,10],"TAG":["first","second"],"EMPTY":null}`
+
+	entries := readEntries(t, NewJSONReader(strings.NewReader(stream)))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+
+	e := entries[0]
+	if e.Cursor != "s=1" || e.RealtimeTimestamp != 1500000000000000 || e.Fields["MESSAGE"] != "multi\nline" {
+		t.Fatalf("got %+v", e)
+	}
+
+	e = entries[1]
+	if e.Fields["MESSAGE"] != "\x00\xff\n" || e.Fields["TAG"] != "first" {
+		t.Fatalf("got %+v", e)
+	}
+	if _, ok := e.Fields["EMPTY"]; ok {
+		t.Fatal("null field kept")
+	}
+}
+
+func TestJSONReaderTruncated(t *testing.T) {
+	r := NewJSONReader(strings.NewReader(`{"MESSAGE":"a"}` + "\n" + `{"MESSAGE":"b`))
+	if _, err := r.ReadEntry(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadEntry(); err == nil || err == io.EOF {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestJSONReaderInvalid(t *testing.T) {
+	for _, stream := range []string{
+		`{"MESSAGE":[256]}`,
+		`{"MESSAGE":{"nested":true}}`,
+		`{"__MONOTONIC_TIMESTAMP":"-1"}`,
+	} {
+		if _, err := NewJSONReader(strings.NewReader(stream)).ReadEntry(); err == nil || err == io.EOF {
+			t.Errorf("%q: got %v", stream, err)
+		}
+	}
+}
@@ -8,6 +8,8 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"time"
@@ -27,14 +29,22 @@ type JournalFollowerConfig struct {
 	// If not empty, the journal instance will point to a journal residing
 	// in this directory. The supplied path may be relative or absolute.
 	Path string
+
+	// If not empty, the journal instance will be made of these files only.
+	// Takes precedence over Path.
+	Files []string
 }
 
 // JournalFollower is an io.ReadCloser which provides a simple interface for iterating through the
 // systemd journal.
 type JournalFollower struct {
 	journal *sdjournal.Journal
+	cursor  string
 }
 
+// followRetryInterval is how long to wait before retrying after an error.
+const followRetryInterval = 1 * time.Second
+
 // NewJournalFollower creates a new JournalFollower with configuration options that are similar to the
 // systemd journalctl tool's iteration and filtering features.
 func NewJournalFollower(config JournalFollowerConfig) (*JournalFollower, error) {
@@ -42,7 +52,9 @@ func NewJournalFollower(config JournalFollowerConfig) (*JournalFollower, error)
 
 	// Open the journal
 	var err error
-	if config.Path != "" {
+	if len(config.Files) > 0 {
+		r.journal, err = sdjournal.NewJournalFromFiles(config.Files...)
+	} else if config.Path != "" {
 		r.journal, err = sdjournal.NewJournalFromDir(config.Path)
 	} else {
 		r.journal, err = sdjournal.NewJournal()
@@ -58,7 +70,7 @@ func NewJournalFollower(config JournalFollowerConfig) (*JournalFollower, error)
 
 	if config.Cursor != "" {
 		// Start based on a custom cursor
-		if err := r.journal.SeekCursor(config.Cursor); err != nil {
+		if err := r.SeekCursor(config.Cursor); err != nil {
 			return nil, err
 		}
 	}
@@ -66,68 +78,133 @@ func NewJournalFollower(config JournalFollowerConfig) (*JournalFollower, error)
 	return r, nil
 }
 
+// SeekCursor positions the journal right after the entry pointed by cursor, so
+// the entry which was already processed is not read twice.
+func (r *JournalFollower) SeekCursor(cursor string) error {
+	if err := r.journal.SeekCursor(cursor); err != nil {
+		return err
+	}
+	r.cursor = cursor
+
+	// sd_journal_seek_cursor leaves the journal in front of the entry, consume
+	// it only if it is still there (it could have been vacuumed in between).
+	n, err := r.journal.Next()
+	if err != nil || n == 0 {
+		return err
+	}
+	current, err := r.journal.GetCursor()
+	if err != nil {
+		return err
+	}
+	if current != cursor {
+		_, err = r.journal.Previous()
+		return err
+	}
+
+	return nil
+}
+
+// SeekRealtime positions the journal at the first entry whose realtime
+// timestamp is equal or greater than t.
+func (r *JournalFollower) SeekRealtime(t time.Time) error {
+	r.cursor = ""
+	return r.journal.SeekRealtimeUsec(uint64(t.UnixNano() / int64(time.Microsecond)))
+}
+
 func (r *JournalFollower) Close() error {
 	return r.journal.Close()
 }
 
-func (r *JournalFollower) Follow(recvc chan<- *sdjournal.JournalEntry,
-                               stopc <-chan time.Time,
-                               donec chan bool,
-                               errc chan<- error) {
-	defer close(donec)
-
-	// Process journal entries and events. Entries are flushed until the tail or
-	// timeout is reached, and then we wait for new events or the timeout.
-	process:
+// Follow sends journal entries to recvc until ctx is done. Once the tail is
+// reached it sleeps until the journal changes, being woken up by the journal
+// file descriptor itself whenever it is available.
+func (r *JournalFollower) Follow(ctx context.Context,
+                                 recvc chan<- *sdjournal.JournalEntry,
+                                 errc chan<- error) {
+	waiter := newJournalWaiter(ctx, r.journal)
+	defer waiter.Close()
+
+	// Process journal entries and events. Entries are flushed until the tail is
+	// reached, and then we wait for new events.
 	for {
 		e, err := r.readEntry()
 		if err != nil && err != io.EOF {
-			break process
+			if !r.recover(ctx, errc, fmt.Errorf("error reading journal: %v", err)) {
+				return
+			}
+			continue
 		}
 
-		select {
-		case <-stopc:
+		if e != nil {
+			select {
+			case recvc <- e:
+				r.cursor = e.Cursor
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// We're at the tail, so wait for new events.
+		event, err := waiter.Wait()
+		if ctx.Err() != nil {
 			return
-		default:
-			if e != nil {
-				recvc <- e
-				continue process
+		}
+		if err != nil {
+			if !r.recover(ctx, errc, fmt.Errorf("error waiting for journal: %v", err)) {
+				return
 			}
+			continue
 		}
 
-		// We're at the tail, so wait for new events or time out.
-		// Holds journal events to process. Tightly bounded for now unless there's a
-		// reason to unblock the journal watch routine more quickly.
-		events := make(chan int, 1)
-		pollDone := make(chan bool, 1)
-		go func() {
-			for {
-				select {
-				case <-pollDone:
+		switch event {
+		case sdjournal.SD_JOURNAL_NOP, sdjournal.SD_JOURNAL_APPEND:
+		case sdjournal.SD_JOURNAL_INVALIDATE:
+			// Journal files were added or removed (e.g. rotated), make sure we
+			// are still right after the last entry we read.
+			if err := r.reseek(); err != nil {
+				if !r.recover(ctx, errc, fmt.Errorf("error seeking journal: %v", err)) {
 					return
-				default:
-					events <- r.journal.Wait(time.Duration(1) * time.Second)
 				}
 			}
-		}()
+		default:
+			log.Printf("Received unknown event: %d\n", event)
+		}
+	}
+}
 
+// recover reports err and backs off before retrying from the last entry read.
+// It returns false if ctx was done in the meantime.
+func (r *JournalFollower) recover(ctx context.Context, errc chan<- error, err error) bool {
+	select {
+	case errc <- err:
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case <-time.After(followRetryInterval):
+	case <-ctx.Done():
+		return false
+	}
+
+	if err := r.reseek(); err != nil {
 		select {
-		case <-stopc:
-			pollDone <- true
-			return
-		case e := <-events:
-			pollDone <- true
-			switch e {
-			case sdjournal.SD_JOURNAL_NOP, sdjournal.SD_JOURNAL_APPEND, sdjournal.SD_JOURNAL_INVALIDATE:
-				// TODO: need to account for any of these?
-			default:
-				log.Printf("Received unknown event: %d\n", e)
-			}
-			continue process
+		case errc <- fmt.Errorf("error seeking journal: %v", err):
+		case <-ctx.Done():
+			return false
 		}
 	}
 
-	return
+	return true
+}
+
+// reseek positions the journal right after the last entry read, if any.
+func (r *JournalFollower) reseek() error {
+	if r.cursor == "" {
+		return nil
+	}
+	return r.SeekCursor(r.cursor)
 }
 
 func (r *JournalFollower) readEntry() (*sdjournal.JournalEntry, error) {
@@ -1,50 +1,148 @@
 package core
 
 import (
+	"context"
+	"crypto/sha1"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/glerchundi/go-systemd/sdjournal"
-	"github.com/glerchundi/journald-forwarder/core/ring"
+)
+
+const (
+	// SourceJournal follows the local systemd journal.
+	SourceJournal = "journal"
+
+	// DefaultCursorPath is where the journal source cursor is persisted by
+	// default, other sources append their name to it.
+	DefaultCursorPath = "/var/run/journald-forwarder/cursor"
 )
 
 type ForwarderConfig struct {
-	RingSize     int
-	Path         string
-	ForwardFlush time.Duration
+	Batch        BatchConfig
+	InFlight     int
+	OrderKeys    []string
+	Source       string
+	Paths        []string
+	Files        []string
+	Namespaces   []string
+	Input        string
+	Remote       RemoteSourceConfig
+	Pipeline     PipelineConfig
+	StageFlush   time.Duration
 	CursorPath   string
 	CursorFlush  time.Duration
 }
 
-func NewForwarderConfig(ringSize int) ForwarderConfig {
+func NewForwarderConfig(bulkSize int) ForwarderConfig {
 	return ForwarderConfig{
-		RingSize:     ringSize,
-		Path:         "/var/log/journal",
-		ForwardFlush: 5 * time.Second,
-		CursorPath:   "/var/run/journald-forwarder/cursor",
+		Batch:        NewBatchConfig(bulkSize),
+		InFlight:     1,
+		Source:       SourceJournal,
+		Paths:        []string{"/var/log/journal"},
+		Input:        "-",
+		Remote:       NewRemoteSourceConfig(),
+		Pipeline:     NewPipelineConfig(),
+		StageFlush:   500 * time.Millisecond,
+		CursorPath:   DefaultCursorPath,
 		CursorFlush:  1 * time.Second,
 	}
 }
 
 type Forwarder struct {
-	follower     *JournalFollower
-	forwardFlush time.Duration
+	source       Source
+	pipeline     *Pipeline
+	checkpointer *checkpointer
+	stageFlush   time.Duration
 
-	ring         *ring.Ring
+	batchConfig  BatchConfig
+	batch        batch
+	inflight     *inflight
+	orderKeys    []string
 
-	cursorc      chan string
+	cursorc      chan inflightCursor
 	cursorPath   string
 	cursorFlush  time.Duration
 
 	recvc        chan *sdjournal.JournalEntry
-	stopc        chan time.Time
+	ctx          context.Context
+	cancel       context.CancelFunc
 	donec        chan bool
 	errc         chan error
+	wg           sync.WaitGroup
 }
 
+// NewForwarder creates a Forwarder reading from the source described by
+// config.Source: the systemd journals residing in config.Paths, config.Files
+// and config.Namespaces, a stream of serialized entries read from
+// config.Input or uploads from remote journals.
 func NewForwarder(config ForwarderConfig) (*Forwarder, error) {
+	if config.CursorPath == DefaultCursorPath {
+		config.CursorPath = defaultCursorPath(config)
+	}
+
+	source, err := newSource(config)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewForwarderWithSource(config, source)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// defaultCursorPath returns where the cursor of the source described by
+// config is persisted unless told otherwise. Sources don't understand each
+// other's cursors, so they don't share it, and neither do streams read from
+// different inputs.
+func defaultCursorPath(config ForwarderConfig) string {
+	switch config.Source {
+	case SourceJournal:
+		return DefaultCursorPath
+	case StreamFormatExport, StreamFormatJSON:
+		input := "stdin"
+		if config.Input != "" && config.Input != "-" {
+			path, err := filepath.Abs(config.Input)
+			if err != nil {
+				path = config.Input
+			}
+			sum := sha1.Sum([]byte(path))
+			input = fmt.Sprintf("%x", sum[:4])
+		}
+		return DefaultCursorPath + "." + config.Source + "-" + input
+	default:
+		return DefaultCursorPath + "." + config.Source
+	}
+}
+
+func newSource(config ForwarderConfig) (Source, error) {
+	switch config.Source {
+	case SourceJournal:
+		return newJournalSource(config)
+	case StreamFormatExport, StreamFormatJSON:
+		return NewStreamSource(StreamSourceConfig{
+			Format: config.Source,
+			Path:   config.Input,
+		})
+	case SourceRemote:
+		return NewRemoteSource(config.Remote)
+	default:
+		return nil, fmt.Errorf("unknown source: %s", config.Source)
+	}
+}
+
+// NewForwarderWithSource creates a Forwarder which reads entries from the
+// supplied source, resuming from the persisted cursor if there is one.
+func NewForwarderWithSource(config ForwarderConfig, source Source) (*Forwarder, error) {
 	// Create cursor file
 	cursor := ""
 	if _, err := os.Stat(config.CursorPath); !os.IsNotExist(err) {
@@ -61,93 +159,317 @@ func NewForwarder(config ForwarderConfig) (*Forwarder, error) {
 		}
 	}
 
-	// Open journal
-	jf, err := NewJournalFollower(JournalFollowerConfig{
-		Cursor: cursor,
-		Path: config.Path,
-	})
+	// Build processing stages
+	pipeline, err := NewPipelineFromConfig(config.Pipeline)
 	if err != nil {
 		return nil, err
 	}
 
+	// Start based on the persisted cursor
+	if cursor != "" {
+		if err := source.SeekCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create forwarder
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Forwarder{
-		follower: jf,
-		forwardFlush: config.ForwardFlush,
+		source: source,
+		pipeline: pipeline,
+		checkpointer: newCheckpointer(pipeline),
+		stageFlush: config.StageFlush,
 
-		ring: ring.NewRing(config.RingSize),
+		batchConfig: config.Batch,
+		inflight: newInflight(config.InFlight),
+		orderKeys: config.OrderKeys,
 
-		cursorc: make(chan string),
+		cursorc: make(chan inflightCursor),
 		cursorPath: config.CursorPath,
 		cursorFlush: config.CursorFlush,
 
 		recvc: make(chan *sdjournal.JournalEntry, 1),
-		stopc: make(chan time.Time),
+		ctx:    ctx,
+		cancel: cancel,
 		donec: make(chan bool),
 		errc:  make(chan error),
 	}, nil
 }
 
-func (f *Forwarder) forward(provider Provider) {
-	defer close(f.donec)
+// newJournalSource opens every configured journal, merging them if there are
+// more than one.
+// openJournal opens the journal described by config. Tests replace it, as
+// journals can't be opened without systemd.
+var openJournal = func(config JournalFollowerConfig) (Source, error) {
+	return NewJournalFollower(config)
+}
 
-	tduration := 10 * time.Second
-	timer := time.NewTimer(tduration)
+func newJournalSource(config ForwarderConfig) (Source, error) {
+	var origins []string
+	var configs []JournalFollowerConfig
+	for _, path := range config.Paths {
+		if path != "" {
+			origins = append(origins, path)
+			configs = append(configs, JournalFollowerConfig{Path: path})
+		}
+	}
+	for _, file := range config.Files {
+		if file != "" {
+			origins = append(origins, file)
+			configs = append(configs, JournalFollowerConfig{Files: []string{file}})
+		}
+	}
+	for _, namespace := range config.Namespaces {
+		if namespace == "" {
+			continue
+		}
+		path, err := NamespacePath(namespace)
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, namespace)
+		configs = append(configs, JournalFollowerConfig{Path: path})
+	}
+
+	switch len(configs) {
+	case 0:
+		return openJournal(JournalFollowerConfig{})
+	case 1:
+		// Keep plain journal cursors (and no labels) for a single journal.
+		return openJournal(configs[0])
+	}
+
+	sources := make([]Source, 0, len(configs))
+	for _, c := range configs {
+		jf, err := openJournal(c)
+		if err != nil {
+			for _, source := range sources {
+				source.Close()
+			}
+			return nil, err
+		}
+		sources = append(sources, jf)
+	}
+
+	return NewMergeSource(origins, sources)
+}
+
+func (f *Forwarder) forward(provider Provider, followc <-chan bool) {
+	defer f.wg.Done()
+	defer f.pipeline.Close()
+
+	size := f.entrySizer(provider)
+	emit := func(e *sdjournal.JournalEntry, cursor string) {
+		f.add(provider, e, cursor, size(e))
+	}
+
+	// Stages holding entries back are given the chance to emit them regularly.
+	var stagec <-chan time.Time
+	if f.pipeline.Len() > 0 {
+		ticker := time.NewTicker(f.stageFlush)
+		defer ticker.Stop()
+		stagec = ticker.C
+	}
+
+	// Batches are published once their first entry gets too old.
+	age := time.NewTimer(f.batchConfig.MaxAge)
+	age.Stop()
+	defer age.Stop()
+	aging := false
 	for {
+		if !aging && f.batch.Len() > 0 {
+			age.Reset(f.batchConfig.MaxAge - time.Since(f.batch.created))
+			aging = true
+		}
+
 		select {
-		case <- timer.C:
-			f.publish(provider, true)
+		case <-age.C:
+			aging = false
+			// The batch may have been replaced meanwhile.
+			if time.Since(f.batch.created) >= f.batchConfig.MaxAge {
+				f.publish(provider)
+			}
+		case now := <-stagec:
+			f.checkpointer.flush(now, emit)
 		case e := <-f.recvc:
-			f.ring.Enqueue(e)
-			f.publish(provider, false)
-		case <-f.stopc:
+			f.checkpointer.process(e, emit)
+		case <-followc:
+			// The source is exhausted, forward what's left and stop.
+			f.drain(provider, emit)
+			f.Stop()
+			return
+		case <-f.ctx.Done():
 			return
 		}
 
-		if !timer.Reset(f.forwardFlush) {
-			timer = time.NewTimer(f.forwardFlush)
+		if aging && f.batch.Len() == 0 {
+			if !age.Stop() {
+				select {
+				case <-age.C:
+				default:
+				}
+			}
+			aging = false
 		}
 	}
 }
 
-func (f *Forwarder) publish(provider Provider, force bool) {
-	if f.ring.Len() == f.ring.Capacity() || force {
-		errorOccurred := true
-		for errorOccurred {
-			n, err := provider.Publish(f.ring.Iterator())
-			if err != nil {
-				f.errc <- err
-				time.Sleep(1 * time.Second)
+// entrySizer returns how entries are measured, as encoded by provider.
+func (f *Forwarder) entrySizer(provider Provider) func(*sdjournal.JournalEntry) int {
+	if sizer, ok := provider.(EntrySizer); ok {
+		return sizer.EntrySize
+	}
+	marshaller := NewJournalEntryMarshaller()
+	return func(e *sdjournal.JournalEntry) int {
+		return len(marshaller.MarshalOne(e))
+	}
+}
+
+// add appends e to the batch, publishing the batch first if e doesn't fit in
+// it, and right after if it's full. cursor is persisted once e is published.
+func (f *Forwarder) add(provider Provider, e *sdjournal.JournalEntry, cursor string, size int) {
+	if f.batchConfig.MaxBytes > 0 && size > f.batchConfig.MaxBytes {
+		f.report(fmt.Errorf("dropped an entry of %d bytes, batches are limited to %d bytes", size, f.batchConfig.MaxBytes))
+		return
+	}
+
+	if f.batch.full(f.batchConfig, size) {
+		f.publish(provider)
+	}
+	f.batch.add(e, cursor, size)
+	if f.batch.full(f.batchConfig, 0) {
+		f.publish(provider)
+	}
+}
+
+// publish hands the batch over to be published concurrently once there's a
+// free slot. It's queued until no batch sharing an ordering key with it is in
+// flight.
+func (f *Forwarder) publish(provider Provider) {
+	if f.batch.Len() == 0 {
+		return
+	}
+	b := f.batch
+	f.batch = batch{}
+
+	f.wg.Add(1)
+	f.inflight.start(f.batchKeys(&b), func(seq uint64) {
+		defer f.wg.Done()
+		f.send(provider, seq, &b)
+	})
+}
+
+// batchKeys returns the distinct ordering keys of the entries of b.
+func (f *Forwarder) batchKeys(b *batch) []string {
+	if len(f.orderKeys) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, e := range b.entries {
+		key := streamKey(e, f.orderKeys)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// send publishes b, retrying until every entry is acknowledged or the
+// forwarder is stopped.
+func (f *Forwarder) send(provider Provider, seq uint64, b *batch) {
+	for b.Len() > 0 && f.ctx.Err() == nil {
+		n, err := provider.Publish(b.Iterator())
+		if n > b.Len() {
+			n = b.Len()
+		}
+		if n > 0 {
+			cursor := b.cursors[n-1]
+			b.remove(n)
+			if c, ok := f.inflight.ack(seq, cursor, b.Len() == 0); ok {
+				f.advance(c)
 			}
-			for i := 0; i < n; i++ {
-				e := f.ring.Dequeue()
-				if i+1 == n {
-					f.cursorc <- e.Cursor
-				}
+		}
+
+		if err != nil {
+			f.report(err)
+		}
+		if err != nil || n <= 0 {
+			select {
+			case <-time.After(1 * time.Second):
+			case <-f.ctx.Done():
+				return
 			}
-			errorOccurred = false
 		}
 	}
 }
 
+// advance hands cursor over to be persisted.
+func (f *Forwarder) advance(cursor inflightCursor) {
+	select {
+	case f.cursorc <- cursor:
+	case <-f.ctx.Done():
+	}
+}
+
+func (f *Forwarder) drain(provider Provider, emit func(*sdjournal.JournalEntry, string)) {
+	for {
+		select {
+		case e := <-f.recvc:
+			f.checkpointer.process(e, emit)
+		default:
+			f.checkpointer.flush(time.Time{}, emit)
+			f.publish(provider)
+			f.inflight.wait()
+			return
+		}
+	}
+}
+
+// report sends a non fatal error, unless the forwarder is stopped.
+func (f *Forwarder) report(err error) {
+	select {
+	case f.errc <- err:
+	case <-f.ctx.Done():
+	}
+}
+
+// FieldTypes returns the types of the fields coerced by the pipeline.
+func (f *Forwarder) FieldTypes() map[string]FieldType {
+	return f.pipeline.FieldTypes()
+}
+
 func (f *Forwarder) cursorPersist(flushFreq time.Duration) {
-	defer close(f.donec)
+	defer f.wg.Done()
 
-	currentCursor := ""
+	currentCursor, writtenCursor := "", ""
+	var current uint64
 	ticker := time.NewTicker(flushFreq)
+	defer ticker.Stop()
 	for {
 		select {
 		case <- ticker.C:
-			if currentCursor == "" {
+			if currentCursor == "" || currentCursor == writtenCursor {
 				break
 			}
 			if err := f.writeCursor(currentCursor); err != nil {
-				f.errc <- err
-				time.Sleep(1 * time.Second)
+				f.report(err)
+				break
 			}
+			writtenCursor = currentCursor
 		case c := <-f.cursorc:
-			currentCursor = c
-		case <-f.stopc:
+			// Batches acknowledged concurrently may hand older cursors late.
+			if c.n > current {
+				currentCursor, current = c.cursor, c.n
+			}
+		case <-f.ctx.Done():
+			// Don't lose the progress made since the last flush.
+			if currentCursor != "" && currentCursor != writtenCursor {
+				if err := f.writeCursor(currentCursor); err != nil {
+					log.Printf("error persisting cursor: %v", err)
+				}
+			}
 			return
 		}
 	}
@@ -177,12 +499,41 @@ func (f *Forwarder) writeCursor(cursor string) error {
 }
 
 func (f *Forwarder) Run(provider Provider) {
+	followc := make(chan bool)
+	f.wg.Add(3)
+
 	// 1.- Start following
-	go f.follower.Follow(f.recvc, f.stopc, f.donec, f.errc)
+	go func() {
+		defer f.wg.Done()
+		defer close(followc)
+		f.source.Follow(f.ctx, f.recvc, f.errc)
+	}()
 
 	// 2.- Start forwarding
-	go f.forward(provider)
+	go f.forward(provider, followc)
 
 	// 3.- Persist cursor
 	go f.cursorPersist(f.cursorFlush)
+
+	// 4.- Signal once everything is stopped
+	go func() {
+		f.wg.Wait()
+		close(f.donec)
+	}()
+}
+
+// Stop asks every running routine to finish. Use Done to wait for them.
+func (f *Forwarder) Stop() {
+	f.cancel()
+}
+
+// Done returns a channel which is closed once the forwarder is fully stopped.
+func (f *Forwarder) Done() <-chan bool {
+	return f.donec
+}
+
+// Errors returns the channel where non fatal errors are reported. It must be
+// drained, otherwise the forwarder ends up blocked.
+func (f *Forwarder) Errors() <-chan error {
+	return f.errc
 }
\ No newline at end of file
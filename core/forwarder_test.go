@@ -0,0 +1,414 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// testProvider records the messages of every published entry.
+type testProvider struct {
+	mu       sync.Mutex
+	messages []string
+	publishc chan bool
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{publishc: make(chan bool, 100)}
+}
+
+func (p *testProvider) Publish(iterator JournalEntryIterator) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for iterator.Next() {
+		_, e := iterator.Value()
+		p.messages = append(p.messages, e.Fields["MESSAGE"])
+		n++
+	}
+	select {
+	case p.publishc <- true:
+	default:
+	}
+	return n, nil
+}
+
+func (p *testProvider) Messages() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.messages...)
+}
+
+// waitMessages waits until p got n messages.
+func (p *testProvider) waitMessages(t *testing.T, n int) []string {
+	deadline := time.After(5 * time.Second)
+	for {
+		if messages := p.Messages(); len(messages) >= n {
+			return messages
+		}
+		select {
+		case <-p.publishc:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out waiting for %d messages, got %v", n, p.Messages())
+		}
+	}
+}
+
+func newTestForwarderConfig(t *testing.T) (ForwarderConfig, func()) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewForwarderConfig(10)
+	config.Batch.MaxAge = 10 * time.Millisecond
+	config.CursorPath = filepath.Join(dir, "cursor")
+	config.CursorFlush = 10 * time.Millisecond
+	return config, func() { os.RemoveAll(dir) }
+}
+
+func appendMessages(s *MemorySource, messages ...string) {
+	for _, m := range messages {
+		s.AppendFields(map[string]string{"MESSAGE": m})
+	}
+}
+
+func runForwarder(t *testing.T, config ForwarderConfig, source Source, provider Provider) *Forwarder {
+	f, err := NewForwarderWithSource(config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Run(provider)
+	return f
+}
+
+func stopForwarder(t *testing.T, f *Forwarder) {
+	f.Stop()
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder didn't stop")
+	}
+}
+
+// waitCursor waits until cursor is persisted at path.
+func waitCursor(t *testing.T, path, cursor string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(path)
+		if string(data) == cursor {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("persisted cursor is %q, want %q", data, cursor)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func assertMessages(t *testing.T, got []string, want ...string) {
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestForwarderResumesFromPersistedCursor(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+
+	source := NewMemorySource()
+	appendMessages(source, "a", "b", "c")
+	provider := newTestProvider()
+	f := runForwarder(t, config, source, provider)
+	provider.waitMessages(t, 3)
+	waitCursor(t, config.CursorPath, "s=memory;i=3")
+	stopForwarder(t, f)
+
+	// The same journal, grown while the forwarder wasn't running
+	source = NewMemorySource()
+	appendMessages(source, "a", "b", "c", "d", "e")
+	provider = newTestProvider()
+	f = runForwarder(t, config, source, provider)
+	defer stopForwarder(t, f)
+	assertMessages(t, provider.waitMessages(t, 2), "d", "e")
+}
+
+func TestForwarderStopsWhenSourceIsClosed(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+
+	source := NewMemorySource()
+	appendMessages(source, "a", "b")
+	source.Close()
+	provider := newTestProvider()
+	f := runForwarder(t, config, source, provider)
+
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder didn't stop once the source was exhausted")
+	}
+	assertMessages(t, provider.Messages(), "a", "b")
+	waitCursor(t, config.CursorPath, "s=memory;i=2")
+}
+
+func TestForwarderReportsSourceErrors(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+
+	source := NewMemorySource()
+	provider := newTestProvider()
+	f := runForwarder(t, config, source, provider)
+	defer stopForwarder(t, f)
+
+	appendMessages(source, "a")
+	provider.waitMessages(t, 1)
+
+	failure := errors.New("bad message")
+	source.Fail(failure)
+	select {
+	case err := <-f.Errors():
+		if err != failure {
+			t.Fatalf("got error %v, want %v", err, failure)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error wasn't reported")
+	}
+
+	// Following goes on
+	appendMessages(source, "b")
+	assertMessages(t, provider.waitMessages(t, 2), "a", "b")
+}
+
+func TestForwarderReseeksOnInvalidation(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+
+	source := NewMemorySource()
+	provider := newTestProvider()
+	f := runForwarder(t, config, source, provider)
+	defer stopForwarder(t, f)
+
+	appendMessages(source, "a", "b")
+	provider.waitMessages(t, 2)
+
+	// Rotated journals must neither replay nor skip entries
+	source.Rotate()
+	appendMessages(source, "c")
+	source.Invalidate()
+	appendMessages(source, "d")
+	assertMessages(t, provider.waitMessages(t, 4), "a", "b", "c", "d")
+
+	time.Sleep(50 * time.Millisecond)
+	assertMessages(t, provider.Messages(), "a", "b", "c", "d")
+}
+
+func TestMemorySourceFollowReturnsOnceClosed(t *testing.T) {
+	source := NewMemorySource()
+	appendMessages(source, "a")
+	source.Close()
+
+	recvc := make(chan *sdjournal.JournalEntry, 10)
+	errc := make(chan error, 10)
+	donec := make(chan bool)
+	go func() {
+		source.Follow(context.Background(), recvc, errc)
+		close(donec)
+	}()
+
+	select {
+	case <-donec:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow didn't return")
+	}
+	if len(recvc) != 1 || len(errc) != 0 {
+		t.Fatalf("got %d entries and %d errors", len(recvc), len(errc))
+	}
+}
+
+// sizingProvider measures entries as their message, counting how many times
+// it's asked to.
+type sizingProvider struct {
+	*testProvider
+	mu      sync.Mutex
+	sizes   int
+	batches [][]string
+}
+
+func (p *sizingProvider) EntrySize(e *sdjournal.JournalEntry) int {
+	p.mu.Lock()
+	p.sizes++
+	p.mu.Unlock()
+	return len(e.Fields["MESSAGE"])
+}
+
+func (p *sizingProvider) Publish(iterator JournalEntryIterator) (int, error) {
+	var batch []string
+	var entries []*sdjournal.JournalEntry
+	for iterator.Next() {
+		_, e := iterator.Value()
+		batch = append(batch, e.Fields["MESSAGE"])
+		entries = append(entries, e)
+	}
+	p.mu.Lock()
+	p.batches = append(p.batches, batch)
+	p.mu.Unlock()
+	return p.testProvider.Publish(&batchIterator{entries: entries})
+}
+
+func TestForwarderMeasuresEntriesOnce(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+	config.Batch.MaxEntries = 0
+	config.Batch.MaxBytes = 4
+
+	source := NewMemorySource()
+	appendMessages(source, "aa", "bb", "c", "d", "eeee")
+	source.Close()
+	provider := &sizingProvider{testProvider: newTestProvider()}
+	f := runForwarder(t, config, source, provider)
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder didn't stop")
+	}
+
+	if provider.sizes != 5 {
+		t.Fatalf("entries were measured %d times", provider.sizes)
+	}
+	want := [][]string{{"aa", "bb"}, {"c", "d"}, {"eeee"}}
+	if len(provider.batches) != len(want) {
+		t.Fatalf("got batches %v, want %v", provider.batches, want)
+	}
+	for i := range want {
+		assertMessages(t, provider.batches[i], want[i]...)
+	}
+}
+
+// unitProvider publishes batches of one entry, holding back those of units
+// until they're released.
+type unitProvider struct {
+	*testProvider
+	mu    sync.Mutex
+	holds map[string]chan bool
+}
+
+func (p *unitProvider) hold(unit string) chan bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.holds[unit] == nil {
+		p.holds[unit] = make(chan bool)
+	}
+	return p.holds[unit]
+}
+
+func (p *unitProvider) Publish(iterator JournalEntryIterator) (int, error) {
+	var entries []*sdjournal.JournalEntry
+	for iterator.Next() {
+		_, e := iterator.Value()
+		entries = append(entries, e)
+	}
+	if len(entries) > 0 {
+		<-p.hold(entries[0].Fields["_SYSTEMD_UNIT"])
+	}
+	return p.testProvider.Publish(&batchIterator{entries: entries})
+}
+
+func appendUnitMessages(s *MemorySource, unit string, messages ...string) {
+	for _, m := range messages {
+		s.AppendFields(map[string]string{"_SYSTEMD_UNIT": unit, "MESSAGE": m})
+	}
+}
+
+func TestForwarderQueuesBatchesOfBusyUnits(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+	config.Batch.MaxEntries = 1
+	config.InFlight = 4
+	config.OrderKeys = []string{"_SYSTEMD_UNIT"}
+
+	source := NewMemorySource()
+	provider := &unitProvider{testProvider: newTestProvider(), holds: make(map[string]chan bool)}
+	close(provider.hold("fast"))
+	f := runForwarder(t, config, source, provider)
+	defer stopForwarder(t, f)
+
+	// The batches of slow wait for each other without holding fast back.
+	appendUnitMessages(source, "slow", "s1", "s2")
+	appendUnitMessages(source, "fast", "f1", "f2")
+	assertMessages(t, provider.waitMessages(t, 2), "f1", "f2")
+
+	// Nothing was published past s1, which isn't yet.
+	time.Sleep(50 * time.Millisecond)
+	data, _ := ioutil.ReadFile(config.CursorPath)
+	if len(data) != 0 {
+		t.Fatalf("persisted cursor %q past an unpublished entry", data)
+	}
+
+	close(provider.hold("slow"))
+	assertMessages(t, provider.waitMessages(t, 4), "f1", "f2", "s1", "s2")
+	waitCursor(t, config.CursorPath, "s=memory;i=4")
+}
+
+// shuffleProvider publishes batches after a random delay.
+type shuffleProvider struct {
+	*testProvider
+}
+
+func (p *shuffleProvider) Publish(iterator JournalEntryIterator) (int, error) {
+	time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+	return p.testProvider.Publish(iterator)
+}
+
+func TestForwarderKeepsUnitsInOrder(t *testing.T) {
+	config, cleanup := newTestForwarderConfig(t)
+	defer cleanup()
+	config.Batch.MaxEntries = 3
+	config.InFlight = 8
+	config.OrderKeys = []string{"_SYSTEMD_UNIT"}
+
+	const entries = 300
+	source := NewMemorySource()
+	for i := 0; i < entries; i++ {
+		appendUnitMessages(source, fmt.Sprint(i%4), fmt.Sprintf("%d/%d", i%4, i))
+	}
+	source.Close()
+	provider := &shuffleProvider{newTestProvider()}
+	f := runForwarder(t, config, source, provider)
+	select {
+	case <-f.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("forwarder didn't stop")
+	}
+
+	messages := provider.Messages()
+	if len(messages) != entries {
+		t.Fatalf("got %d messages, want %d", len(messages), entries)
+	}
+	last := make(map[string]int)
+	for _, m := range messages {
+		var unit string
+		var i int
+		fmt.Sscanf(m, "%1s/%d", &unit, &i)
+		if prev, ok := last[unit]; ok && i < prev {
+			t.Fatalf("%s published after %s/%d", m, unit, prev)
+		}
+		last[unit] = i
+	}
+	waitCursor(t, config.CursorPath, fmt.Sprintf("s=memory;i=%x", entries))
+}
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	CloudAuto  = "auto"
+	CloudEC2   = "ec2"
+	CloudGCE   = "gce"
+	CloudAzure = "azure"
+
+	// Fields added to every entry describing the host.
+	HostNameField          = "host.name"
+	HostMachineIDField     = "host.machine_id"
+	HostKernelField        = "host.kernel"
+	HostOSPrefix           = "host.os."
+	CloudProviderField     = "cloud.provider"
+	CloudInstanceIDField   = "cloud.instance_id"
+	CloudInstanceTypeField = "cloud.instance_type"
+	CloudRegionField       = "cloud.region"
+	CloudZoneField         = "cloud.availability_zone"
+	CloudAccountIDField    = "cloud.account_id"
+)
+
+// HostConfig represents options to drive the behavior of a HostStage.
+type HostConfig struct {
+	Enabled bool
+
+	// Root of the host file system, to read /etc/machine-id and
+	// /etc/os-release from when running inside a container.
+	Root string
+
+	// Cloud to fetch instance metadata from: auto, ec2, gce or azure. Empty
+	// means none.
+	Cloud string
+
+	// Base URL of the instance metadata service.
+	CloudEndpoint string
+
+	// Timeout of every request to the instance metadata service.
+	Timeout time.Duration
+
+	// Static KEY=VALUE tags.
+	Tags []string
+}
+
+// NewHostConfig creates a HostConfig reading the local file system.
+func NewHostConfig() HostConfig {
+	return HostConfig{
+		Root:          "/",
+		CloudEndpoint: "http://169.254.169.254",
+		Timeout:       2 * time.Second,
+	}
+}
+
+// IsZero reports whether host enrichment is disabled.
+func (c HostConfig) IsZero() bool {
+	return !c.Enabled && len(c.Tags) == 0
+}
+
+// HostStage adds host level metadata to every entry: hostname, machine id,
+// operating system, kernel version, cloud instance metadata and static tags.
+// It's all gathered once, when the stage is created.
+type HostStage struct {
+	fields map[string]string
+}
+
+// NewHostStage creates a HostStage as described by config.
+func NewHostStage(config HostConfig) (*HostStage, error) {
+	s := &HostStage{fields: make(map[string]string)}
+
+	if config.Enabled {
+		s.collectHost(config.Root)
+
+		if config.Cloud != "" {
+			if err := s.collectCloud(config); err != nil {
+				log.Printf("unable to fetch cloud instance metadata: %v", err)
+			}
+		}
+	}
+
+	tags, err := splitPairs(config.Tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range tags {
+		s.fields[p[0]] = p[1]
+	}
+
+	return s, nil
+}
+
+func (s *HostStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	for k, v := range s.fields {
+		e.Fields[k] = v
+	}
+	emit(e)
+}
+
+func (s *HostStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// Fields returns the fields added to every entry.
+func (s *HostStage) Fields() map[string]string {
+	return s.fields
+}
+
+func (s *HostStage) collectHost(root string) {
+	if hostname, err := os.Hostname(); err == nil {
+		s.fields[HostNameField] = hostname
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(root, "etc/machine-id")); err == nil {
+		s.fields[HostMachineIDField] = strings.TrimSpace(string(data))
+	}
+
+	if data, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		s.fields[HostKernelField] = strings.TrimSpace(string(data))
+	}
+
+	for k, v := range readOSRelease(root) {
+		s.fields[HostOSPrefix+strings.ToLower(k)] = v
+	}
+}
+
+// readOSRelease parses os-release(5) from the host root.
+func readOSRelease(root string) map[string]string {
+	fields := make(map[string]string)
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		f, err := os.Open(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.Index(line, "=")
+			if i <= 0 {
+				continue
+			}
+			value := line[i+1:]
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else {
+				value = strings.Trim(value, `'"`)
+			}
+			fields[line[:i]] = value
+		}
+		break
+	}
+	return fields
+}
+
+func (s *HostStage) collectCloud(config HostConfig) error {
+	transport, err := newHTTPTransport(newMetadataHTTPConfig(config.Timeout))
+	if err != nil {
+		return err
+	}
+	// Instance metadata is only reachable from the instance itself, never
+	// through a proxy.
+	transport.Proxy = nil
+	client := &http.Client{Transport: transport, Timeout: config.Timeout}
+	endpoint := strings.TrimSuffix(config.CloudEndpoint, "/")
+
+	fetchers := map[string]func(*http.Client, string) (map[string]string, error){
+		CloudEC2:   fetchEC2Metadata,
+		CloudGCE:   fetchGCEMetadata,
+		CloudAzure: fetchAzureMetadata,
+	}
+
+	clouds := []string{config.Cloud}
+	if config.Cloud == CloudAuto {
+		clouds = []string{CloudEC2, CloudGCE, CloudAzure}
+	}
+
+	var lastErr error
+	for _, cloud := range clouds {
+		fetch, ok := fetchers[cloud]
+		if !ok {
+			return fmt.Errorf("unknown cloud: %s", cloud)
+		}
+		fields, err := fetch(client, endpoint)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", cloud, err)
+			continue
+		}
+		s.fields[CloudProviderField] = cloud
+		for k, v := range fields {
+			if v != "" {
+				s.fields[k] = v
+			}
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// fetchEC2Metadata reads the instance identity document using IMDSv2.
+func fetchEC2Metadata(client *http.Client, endpoint string) (map[string]string, error) {
+	req, err := http.NewRequest("PUT", endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := fetchMetadata(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequest("GET", endpoint+"/latest/dynamic/instance-identity/document", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	data, err := fetchMetadata(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		AccountID        string `json:"accountId"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		CloudInstanceIDField:   doc.InstanceID,
+		CloudInstanceTypeField: doc.InstanceType,
+		CloudRegionField:       doc.Region,
+		CloudZoneField:         doc.AvailabilityZone,
+		CloudAccountIDField:    doc.AccountID,
+	}, nil
+}
+
+// fetchGCEMetadata reads the compute metadata of the instance.
+func fetchGCEMetadata(client *http.Client, endpoint string) (map[string]string, error) {
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest("GET", endpoint+"/computeMetadata/v1/"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return fetchMetadata(client, req)
+	}
+
+	data, err := get("instance/?recursive=true")
+	if err != nil {
+		return nil, err
+	}
+	var instance struct {
+		ID          json.Number `json:"id"`
+		MachineType string      `json:"machineType"`
+		Zone        string      `json:"zone"`
+	}
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return nil, err
+	}
+
+	// Zone and machine type are given as projects/<n>/zones/<zone>.
+	zone := lastPathElement(instance.Zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+
+	fields := map[string]string{
+		CloudInstanceIDField:   instance.ID.String(),
+		CloudInstanceTypeField: lastPathElement(instance.MachineType),
+		CloudRegionField:       region,
+		CloudZoneField:         zone,
+	}
+	if project, err := get("project/project-id"); err == nil {
+		fields[CloudAccountIDField] = string(project)
+	}
+	return fields, nil
+}
+
+// fetchAzureMetadata reads the compute metadata of the instance.
+func fetchAzureMetadata(client *http.Client, endpoint string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", endpoint+"/metadata/instance?api-version=2021-02-01", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	data, err := fetchMetadata(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Compute struct {
+			VMID           string `json:"vmId"`
+			VMSize         string `json:"vmSize"`
+			Location       string `json:"location"`
+			Zone           string `json:"zone"`
+			SubscriptionID string `json:"subscriptionId"`
+		} `json:"compute"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		CloudInstanceIDField:   doc.Compute.VMID,
+		CloudInstanceTypeField: doc.Compute.VMSize,
+		CloudRegionField:       doc.Compute.Location,
+		CloudZoneField:         doc.Compute.Zone,
+		CloudAccountIDField:    doc.Compute.SubscriptionID,
+	}, nil
+}
+
+func fetchMetadata(client *http.Client, req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func lastPathElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
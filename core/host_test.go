@@ -0,0 +1,193 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// newTestHostRoot creates a host file system with a machine id and an
+// os-release.
+func newTestHostRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"etc/machine-id": "0123456789abcdef0123456789abcdef\n",
+		"etc/os-release": "# comment\n\nNAME=\"Debian GNU/Linux\"\nVERSION_ID='12'\nID=debian\n" +
+			`PRETTY_NAME="Debian \"bookworm\""` + "\ninvalid\n",
+		"usr/lib/os-release": "ID=ignored\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestHostStageCollectsHost(t *testing.T) {
+	root := newTestHostRoot(t)
+	defer os.RemoveAll(root)
+
+	config := NewHostConfig()
+	config.Enabled = true
+	config.Root = root
+	config.Tags = []string{"env=prod"}
+	s, err := NewHostStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostname, _ := os.Hostname()
+	want := map[string]string{
+		HostNameField:                hostname,
+		HostMachineIDField:           "0123456789abcdef0123456789abcdef",
+		HostOSPrefix + "name":        "Debian GNU/Linux",
+		HostOSPrefix + "version_id":  "12",
+		HostOSPrefix + "id":          "debian",
+		HostOSPrefix + "pretty_name": `Debian "bookworm"`,
+		"env":                        "prod",
+	}
+	fields := s.Fields()
+	delete(fields, HostKernelField)
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("got %v, want %v", fields, want)
+	}
+
+	e := processEntry(t, s, &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello"}})
+	if e.Fields["MESSAGE"] != "hello" || e.Fields["env"] != "prod" || e.Fields[HostNameField] != hostname {
+		t.Fatalf("got %v", e.Fields)
+	}
+}
+
+func TestHostStageTagsOnly(t *testing.T) {
+	s, err := NewHostStage(HostConfig{Tags: []string{"env=prod", "team=infra"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"env": "prod", "team": "infra"}; !reflect.DeepEqual(s.Fields(), want) {
+		t.Fatalf("got %v, want %v", s.Fields(), want)
+	}
+
+	if _, err := NewHostStage(HostConfig{Tags: []string{"env"}}); err == nil {
+		t.Fatal("invalid tag accepted")
+	}
+}
+
+// newTestMetadataServer serves the instance metadata of cloud, failing every
+// other cloud's requests.
+func newTestMetadataServer(cloud string) *httptest.Server {
+	mux := http.NewServeMux()
+	switch cloud {
+	case CloudEC2:
+		mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				http.Error(w, "token required", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("token"))
+		})
+		mux.HandleFunc("/latest/dynamic/instance-identity/document", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+				http.Error(w, "token required", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"instanceId":"i-0123","instanceType":"m5.large","region":"eu-west-1",` +
+				`"availabilityZone":"eu-west-1a","accountId":"123456789012"}`))
+		})
+	case CloudGCE:
+		mux.HandleFunc("/computeMetadata/v1/", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "flavor required", http.StatusForbidden)
+				return
+			}
+			switch r.URL.Path {
+			case "/computeMetadata/v1/instance/":
+				w.Write([]byte(`{"id":4520031799277581759,"machineType":"projects/42/machineTypes/e2-medium",` +
+					`"zone":"projects/42/zones/europe-west1-b"}`))
+			case "/computeMetadata/v1/project/project-id":
+				w.Write([]byte("my-project"))
+			default:
+				http.NotFound(w, r)
+			}
+		})
+	case CloudAzure:
+		mux.HandleFunc("/metadata/instance", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" {
+				http.Error(w, "metadata required", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"compute":{"vmId":"02aab8a4","vmSize":"Standard_D2s_v3","location":"westeurope",` +
+				`"zone":"","subscriptionId":"8d10da13"}}`))
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+func TestHostStageCollectsCloud(t *testing.T) {
+	tests := []struct {
+		cloud, config string
+		want          map[string]string
+	}{
+		{CloudEC2, CloudEC2, map[string]string{
+			CloudProviderField:     CloudEC2,
+			CloudInstanceIDField:   "i-0123",
+			CloudInstanceTypeField: "m5.large",
+			CloudRegionField:       "eu-west-1",
+			CloudZoneField:         "eu-west-1a",
+			CloudAccountIDField:    "123456789012",
+		}},
+		{CloudGCE, CloudAuto, map[string]string{
+			CloudProviderField:     CloudGCE,
+			CloudInstanceIDField:   "4520031799277581759",
+			CloudInstanceTypeField: "e2-medium",
+			CloudRegionField:       "europe-west1",
+			CloudZoneField:         "europe-west1-b",
+			CloudAccountIDField:    "my-project",
+		}},
+		{CloudAzure, CloudAuto, map[string]string{
+			CloudProviderField:     CloudAzure,
+			CloudInstanceIDField:   "02aab8a4",
+			CloudInstanceTypeField: "Standard_D2s_v3",
+			CloudRegionField:       "westeurope",
+			CloudAccountIDField:    "8d10da13",
+		}},
+		{CloudAzure, CloudEC2, map[string]string{}},
+	}
+
+	for _, test := range tests {
+		server := newTestMetadataServer(test.cloud)
+		config := NewHostConfig()
+		config.Enabled = true
+		config.Cloud = test.config
+		config.CloudEndpoint = server.URL + "/"
+		s, err := NewHostStage(config)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string]string)
+		for k, v := range s.Fields() {
+			if strings.HasPrefix(k, "cloud.") {
+				got[k] = v
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s as %s: got %v, want %v", test.cloud, test.config, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HTTPConfig represents options shared by providers sending entries over
+// HTTP.
+type HTTPConfig struct {
+	// Compression of request bodies: gzip, zstd, snappy or empty for none.
+	Compression string
+
+	// Compression level, 0 meaning the default of the algorithm.
+	CompressionLevel int
+
+	// Timeout of whole requests, response included.
+	Timeout time.Duration
+
+	// Timeouts of connection establishment and TLS handshakes.
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+
+	// CA bundle to verify endpoints against, instead of the system one.
+	CAFile string
+
+	// Client certificate and key, for mutual TLS.
+	CertFile string
+	KeyFile  string
+
+	// Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.
+	TLSMinVersion string
+
+	// Proxy URL. HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honored if empty.
+	Proxy string
+
+	// Keep-alive period of connections, and how many idle ones are kept per
+	// host and for how long. DisableKeepAlives uses a connection per request.
+	KeepAlive           time.Duration
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	DisableKeepAlives   bool
+}
+
+// NewHTTPConfig creates an HTTPConfig sending uncompressed requests with
+// sensible timeouts.
+func NewHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		Timeout:             30 * time.Second,
+		DialTimeout:         10 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSMinVersion:       "1.2",
+		KeepAlive:           30 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// DefaultHTTPConfig is used by clients created with NewHTTPClient. It's set up
+// by Main from the command line.
+var DefaultHTTPConfig = NewHTTPConfig()
+
+// HTTPClient posts payloads to HTTP endpoints, compressing them as
+// configured. It's safe for concurrent use.
+type HTTPClient struct {
+	client      *http.Client
+	userAgent   string
+	compression string
+	compressor  compressor
+}
+
+// NewHTTPClient creates an HTTPClient configured with DefaultHTTPConfig, for
+// an endpoint accepting the supported compressions.
+func NewHTTPClient(supported ...string) (*HTTPClient, error) {
+	return NewHTTPClientWithConfig(DefaultHTTPConfig, supported...)
+}
+
+// NewHTTPClientWithConfig creates an HTTPClient configured with config, for an
+// endpoint accepting the supported compressions.
+func NewHTTPClientWithConfig(config HTTPConfig, supported ...string) (*HTTPClient, error) {
+	transport, err := newHTTPTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &HTTPClient{
+		client:    &http.Client{Transport: transport, Timeout: config.Timeout},
+		userAgent: fmt.Sprintf("%s (version: %s)", cliName, Version),
+	}
+
+	if config.Compression != CompressionNone {
+		if !containsString(supported, config.Compression) {
+			return nil, fmt.Errorf("compression not supported by the endpoint: %s", config.Compression)
+		}
+		compressor, err := newCompressor(config.Compression, config.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		c.compression, c.compressor = config.Compression, compressor
+	}
+
+	return c, nil
+}
+
+// newHTTPTransport creates a transport as described by config. HTTP/2 is
+// negotiated with endpoints supporting it, despite the custom TLS settings.
+func newHTTPTransport(config HTTPConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{}
+	switch config.TLSMinVersion {
+	case "1.0":
+		tlsConfig.MinVersion = tls.VersionTLS10
+	case "1.1":
+		tlsConfig.MinVersion = tls.VersionTLS11
+	case "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unknown tls version: %s", config.TLSMinVersion)
+	}
+
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: config.TLSHandshakeTimeout,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		IdleConnTimeout:     config.IdleConnTimeout,
+		DisableKeepAlives:   config.DisableKeepAlives,
+		ForceAttemptHTTP2:   true,
+	}
+	return transport, nil
+}
+
+// loadCertPool reads a PEM encoded CA bundle.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// Post sends body to url, along with header. The caller must close the body
+// of the response.
+func (c *HTTPClient) Post(url, contentType string, body []byte, header http.Header) (*http.Response, error) {
+	if c.compressor != nil {
+		// Not pooled, the transport may still be reading it once Do returns.
+		var buf bytes.Buffer
+		if err := c.compressor.compress(&buf, body); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	// Content-Length is set from the reader.
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req, contentType, header)
+
+	return c.client.Do(req)
+}
+
+// PostStream sends what write writes to url, along with header, as a chunked
+// body: it's piped to the connection (compressed on the fly if the compressor
+// supports it) as it's written, instead of being held in memory. Writes are
+// buffered into chunks of bodyChunkSize bytes. The caller must close the body
+// of the response.
+func (c *HTTPClient) PostStream(url, contentType string, write func(w io.Writer) error, header http.Header) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("POST", url, pr)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req, contentType, header)
+
+	// The transport closes the reader once it's done with the request, even
+	// if it fails early, which unblocks the writer.
+	go func() {
+		pw.CloseWithError(c.writeBody(pw, write))
+	}()
+
+	return c.client.Do(req)
+}
+
+// bodyChunkSize is the size of the chunks of streamed bodies. Small writes
+// (e.g. one per entry) would otherwise each go through the pipe and be sent as
+// a chunk of their own.
+const bodyChunkSize = 32 * 1024
+
+var bodyWriters = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, bodyChunkSize)
+	},
+}
+
+// writeBody compresses what write writes to w.
+func (c *HTTPClient) writeBody(w io.Writer, write func(w io.Writer) error) error {
+	if sc, ok := c.compressor.(streamCompressor); ok {
+		cw := sc.writer(w)
+		if err := writeBuffered(cw, write); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	}
+
+	if c.compressor == nil {
+		return writeBuffered(w, write)
+	}
+
+	// Compressed as a whole.
+	var body, buf bytes.Buffer
+	if err := write(&body); err != nil {
+		return err
+	}
+	if err := c.compressor.compress(&buf, body.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeBuffered buffers what write writes to w.
+func writeBuffered(w io.Writer, write func(w io.Writer) error) error {
+	bw := bodyWriters.Get().(*bufio.Writer)
+	defer bodyWriters.Put(bw)
+	bw.Reset(w)
+	defer bw.Reset(nil)
+
+	if err := write(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (c *HTTPClient) setHeaders(req *http.Request, contentType string, header http.Header) {
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Content-Type", contentType)
+	if c.compression != CompressionNone {
+		req.Header.Set("Content-Encoding", c.compression)
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
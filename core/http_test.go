@@ -0,0 +1,214 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// newTestHTTPServer records the decompressed bodies it's sent, rejecting
+// truncated ones.
+func newTestHTTPServer(bodyc chan<- []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == CompressionGzip {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if bodyc != nil {
+			bodyc <- data
+		}
+	}))
+}
+
+func newTestHTTPClient(t testing.TB, compression string) *HTTPClient {
+	config := NewHTTPConfig()
+	config.Compression = compression
+	c, err := NewHTTPClientWithConfig(config, CompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHTTPClientPostStream(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		bodyc := make(chan []byte, 1)
+		server := newTestHTTPServer(bodyc)
+
+		c := newTestHTTPClient(t, compression)
+		res, err := c.PostStream(server.URL, "text/plain", func(w io.Writer) error {
+			for _, line := range []string{"a\n", "b\n"} {
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		server.Close()
+
+		if body := <-bodyc; string(body) != "a\nb\n" {
+			t.Errorf("compression %q: got body %q", compression, body)
+		}
+	}
+}
+
+func TestHTTPClientPostStreamWriteError(t *testing.T) {
+	server := newTestHTTPServer(nil)
+	defer server.Close()
+
+	failure := errors.New("can't encode")
+	c := newTestHTTPClient(t, CompressionGzip)
+	_, err := c.PostStream(server.URL, "text/plain", func(w io.Writer) error {
+		io.WriteString(w, "a\n")
+		return failure
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), failure.Error()) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+}
+
+func TestHTTPClientNegotiatesHTTP2(t *testing.T) {
+	protoc := make(chan int, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protoc <- r.ProtoMajor
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// Custom TLS settings must not turn HTTP/2 off.
+	dir, err := ioutil.TempDir("", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := NewHTTPConfig()
+	config.CAFile = caFile
+	c, err := NewHTTPClientWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.Post(server.URL, "text/plain", []byte("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if proto := <-protoc; proto != 2 {
+		t.Fatalf("request sent over HTTP/%d", proto)
+	}
+}
+
+func TestHTTPTransportTLSMinVersion(t *testing.T) {
+	versions := map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	for version, want := range versions {
+		config := NewHTTPConfig()
+		config.TLSMinVersion = version
+		transport, err := newHTTPTransport(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := transport.TLSClientConfig.MinVersion; got != want {
+			t.Errorf("%s: min version is %x, want %x", version, got, want)
+		}
+	}
+
+	config := NewHTTPConfig()
+	config.TLSMinVersion = "1.4"
+	if _, err := newHTTPTransport(config); err == nil {
+		t.Fatal("unknown tls version accepted")
+	}
+}
+
+func benchmarkPost(b *testing.B, post func(c *HTTPClient, url string, entries []*sdjournal.JournalEntry) error) {
+	server := newTestHTTPServer(nil)
+	defer server.Close()
+	c := newTestHTTPClient(b, CompressionGzip)
+	entries := newBenchmarkEntries(100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := post(c, server.URL, entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var benchmarkNewline = []byte{'\n'}
+
+// BenchmarkHTTPClientPost sends newline separated batches buffered in memory.
+func BenchmarkHTTPClientPost(b *testing.B) {
+	encoder, _ := NewStreamEncoder(EncodingJSON)
+	benchmarkPost(b, func(c *HTTPClient, url string, entries []*sdjournal.JournalEntry) error {
+		var body bytes.Buffer
+		for _, e := range entries {
+			encoder.Encode(&body, e)
+			body.WriteByte('\n')
+		}
+		res, err := c.Post(url, encoder.ContentType(), body.Bytes(), nil)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	})
+}
+
+// BenchmarkHTTPClientPostStream sends newline separated batches encoded as
+// they're sent.
+func BenchmarkHTTPClientPostStream(b *testing.B) {
+	encoder, _ := NewStreamEncoder(EncodingJSON)
+	benchmarkPost(b, func(c *HTTPClient, url string, entries []*sdjournal.JournalEntry) error {
+		res, err := c.PostStream(url, encoder.ContentType(), func(w io.Writer) error {
+			for _, e := range entries {
+				if err := encoder.Encode(w, e); err != nil {
+					return err
+				}
+				if _, err := w.Write(benchmarkNewline); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	})
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// Fields added to entries with numeric credentials and boot ids.
+	UserNameField       = "USER_NAME"
+	GroupNameField      = "GROUP_NAME"
+	AuditLoginNameField = "AUDIT_LOGIN_NAME"
+	BootIndexField      = "BOOT_INDEX"
+)
+
+// IdentityConfig represents options to drive the behavior of an
+// IdentityStage.
+type IdentityConfig struct {
+	Enabled bool
+
+	// Root of the host file system, to read /etc/passwd, /etc/group,
+	// /etc/machine-id and the current boot id from.
+	Root string
+
+	// Minimum time between reloads of passwd and group when an unknown id
+	// shows up.
+	Reload time.Duration
+}
+
+// NewIdentityConfig creates an IdentityConfig reading the local file system.
+func NewIdentityConfig() IdentityConfig {
+	return IdentityConfig{
+		Root:   "/",
+		Reload: 1 * time.Minute,
+	}
+}
+
+// IsZero reports whether identity resolution is disabled.
+func (c IdentityConfig) IsZero() bool {
+	return !c.Enabled
+}
+
+// IdentityStage resolves _UID, _GID and _AUDIT_LOGINUID to user and group
+// names, and _BOOT_ID to a boot index: 0 is the current boot, -1 the previous
+// one and so on.
+//
+// Boots are ordered by the first entry seen from each of them, per machine,
+// since the stage was created. The journal isn't asked for its boot list, so
+// indexes only match the ones of journalctl --list-boots if every boot was
+// read, e.g. when forwarding starts from the head of the journal. The current
+// boot of the local machine always has index 0, on other machines the latest
+// boot seen does.
+type IdentityStage struct {
+	root   string
+	reload time.Duration
+
+	users    map[string]string
+	groups   map[string]string
+	loadedAt time.Time
+
+	machineID string
+	bootID    string
+	boots     map[string][]identityBoot
+}
+
+type identityBoot struct {
+	id    string
+	first uint64
+}
+
+// NewIdentityStage creates an IdentityStage as described by config.
+func NewIdentityStage(config IdentityConfig) (*IdentityStage, error) {
+	s := &IdentityStage{
+		root:   config.Root,
+		reload: config.Reload,
+		boots:  make(map[string][]identityBoot),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(config.Root, "etc/machine-id")); err == nil {
+		s.machineID = strings.TrimSpace(string(data))
+	}
+	if data, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id"); err == nil {
+		// Journal boot ids come without dashes.
+		s.bootID = strings.Replace(strings.TrimSpace(string(data)), "-", "", -1)
+	}
+
+	return s, nil
+}
+
+func (s *IdentityStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	if name, ok := s.lookup(e.Fields["_UID"], false); ok {
+		e.Fields[UserNameField] = name
+	}
+	if name, ok := s.lookup(e.Fields["_GID"], true); ok {
+		e.Fields[GroupNameField] = name
+	}
+	if name, ok := s.lookup(e.Fields["_AUDIT_LOGINUID"], false); ok {
+		e.Fields[AuditLoginNameField] = name
+	}
+	if bootID := e.Fields["_BOOT_ID"]; bootID != "" {
+		index := s.bootIndex(e.Fields["_MACHINE_ID"], bootID, e.RealtimeTimestamp)
+		e.Fields[BootIndexField] = strconv.Itoa(index)
+	}
+	emit(e)
+}
+
+func (s *IdentityStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// FieldTypes returns the types of the fields added by the stage.
+func (s *IdentityStage) FieldTypes() map[string]FieldType {
+	return map[string]FieldType{BootIndexField: FieldInt}
+}
+
+// lookup resolves id to a user name, or a group name if group is set,
+// reloading passwd and group if it's unknown and they weren't reloaded
+// recently.
+func (s *IdentityStage) lookup(id string, group bool) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	names := s.users
+	if group {
+		names = s.groups
+	}
+	if name, ok := names[id]; ok {
+		return name, true
+	}
+	if time.Since(s.loadedAt) < s.reload || s.load() != nil {
+		return "", false
+	}
+	names = s.users
+	if group {
+		names = s.groups
+	}
+	name, ok := names[id]
+	return name, ok
+}
+
+func (s *IdentityStage) load() error {
+	users, err := readIDNames(filepath.Join(s.root, "etc/passwd"))
+	if err != nil {
+		return err
+	}
+	groups, err := readIDNames(filepath.Join(s.root, "etc/group"))
+	if err != nil {
+		return err
+	}
+	s.users, s.groups, s.loadedAt = users, groups, time.Now()
+	return nil
+}
+
+// readIDNames maps ids to names from a passwd(5) or group(5) file, both of
+// them have the name first and the id third.
+func readIDNames(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		if _, ok := names[parts[2]]; !ok {
+			names[parts[2]] = parts[0]
+		}
+	}
+	return names, scanner.Err()
+}
+
+func (s *IdentityStage) bootIndex(machineID, bootID string, realtime uint64) int {
+	if machineID == "" {
+		machineID = s.machineID
+	}
+	local := machineID == s.machineID && s.bootID != ""
+
+	boots := s.boots[machineID]
+	found := false
+	for i := range boots {
+		if boots[i].id == bootID {
+			if realtime < boots[i].first {
+				boots[i].first = realtime
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		boots = append(boots, identityBoot{id: bootID, first: realtime})
+	}
+	sort.Slice(boots, func(i, j int) bool {
+		// The current boot goes last, whatever was seen from it.
+		if local && boots[i].id == s.bootID {
+			return false
+		}
+		if local && boots[j].id == s.bootID {
+			return true
+		}
+		return boots[i].first < boots[j].first
+	})
+	s.boots[machineID] = boots
+
+	last := len(boots) - 1
+	if local && boots[last].id != s.bootID {
+		// The current boot wasn't seen yet, count it anyway.
+		last++
+	}
+	for i := range boots {
+		if boots[i].id == bootID {
+			return i - last
+		}
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	testPasswd = "# users\nroot:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/sh\n" +
+		"\ninvalid\ntoor:x:0:0::/root:/bin/sh\n"
+	testGroup = "root:x:0:\nstaff:x:50:alice\n"
+)
+
+// newTestIdentityStage creates an IdentityStage reading a host file system
+// of its own, taking "current" to be the current boot of machine "local".
+func newTestIdentityStage(t *testing.T, reload time.Duration) (*IdentityStage, string) {
+	root, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"passwd": testPasswd, "group": testGroup, "machine-id": "local\n"} {
+		if err := ioutil.WriteFile(filepath.Join(root, "etc", name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := NewIdentityConfig()
+	config.Enabled = true
+	config.Root = root
+	config.Reload = reload
+	s, err := NewIdentityStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.machineID != "local" {
+		t.Fatalf("got machine id %q", s.machineID)
+	}
+	s.bootID = "current"
+	return s, root
+}
+
+func TestIdentityStageResolvesNames(t *testing.T) {
+	s, root := newTestIdentityStage(t, time.Hour)
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		fields map[string]string
+		want   map[string]string
+	}{
+		{
+			map[string]string{"_UID": "1000", "_GID": "50", "_AUDIT_LOGINUID": "1000"},
+			map[string]string{UserNameField: "alice", GroupNameField: "staff", AuditLoginNameField: "alice"},
+		},
+		{
+			// The first name of an id wins.
+			map[string]string{"_UID": "0", "_GID": "0"},
+			map[string]string{UserNameField: "root", GroupNameField: "root"},
+		},
+		{
+			map[string]string{"_UID": "4242", "_GID": "1000", "_AUDIT_LOGINUID": "4294967295"},
+			map[string]string{UserNameField: "", GroupNameField: "", AuditLoginNameField: ""},
+		},
+		{
+			map[string]string{"MESSAGE": "kernel"},
+			map[string]string{UserNameField: "", GroupNameField: "", AuditLoginNameField: ""},
+		},
+	}
+
+	for _, test := range tests {
+		e := processEntry(t, s, &sdjournal.JournalEntry{Fields: test.fields})
+		for field, want := range test.want {
+			if got, ok := e.Fields[field]; got != want || ok != (want != "") {
+				t.Errorf("%v: got %s %q, want %q", test.fields, field, got, want)
+			}
+		}
+	}
+}
+
+func TestIdentityStageReloadsUnknownIDs(t *testing.T) {
+	for _, reload := range []time.Duration{0, time.Hour} {
+		s, root := newTestIdentityStage(t, reload)
+		defer os.RemoveAll(root)
+
+		passwd := testPasswd + "bob:x:1001:1001::/home/bob:/bin/sh\n"
+		if err := ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte(passwd), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		want := ""
+		if reload == 0 {
+			want = "bob"
+		}
+		e := processEntry(t, s, &sdjournal.JournalEntry{Fields: map[string]string{"_UID": "1001"}})
+		if got := e.Fields[UserNameField]; got != want {
+			t.Errorf("reload every %v: got %q, want %q", reload, got, want)
+		}
+	}
+}
+
+func TestIdentityStageIndexesBoots(t *testing.T) {
+	s, root := newTestIdentityStage(t, time.Hour)
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		machine, boot string
+		realtime      uint64
+		want          string
+	}{
+		// The current boot is 0 even before it's seen.
+		{"", "first", 100, "-1"},
+		{"local", "second", 200, "-1"},
+		{"local", "first", 150, "-2"},
+		{"local", "current", 300, "0"},
+		{"", "second", 250, "-1"},
+		// Late entries of an older boot don't reorder it.
+		{"local", "first", 400, "-2"},
+		// An earlier boot showing up later goes before the others.
+		{"local", "zeroth", 50, "-3"},
+		{"local", "current", 500, "0"},
+		// On other machines the latest boot seen is 0.
+		{"remote", "old", 100, "0"},
+		{"remote", "new", 200, "0"},
+		{"remote", "old", 300, "-1"},
+	}
+
+	for _, test := range tests {
+		fields := map[string]string{"_BOOT_ID": test.boot}
+		if test.machine != "" {
+			fields["_MACHINE_ID"] = test.machine
+		}
+		e := processEntry(t, s, &sdjournal.JournalEntry{RealtimeTimestamp: test.realtime, Fields: fields})
+		if got := e.Fields[BootIndexField]; got != test.want {
+			t.Errorf("%s/%s at %d: got %s, want %s", test.machine, test.boot, test.realtime, got, test.want)
+		}
+	}
+
+	e := processEntry(t, s, &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "no boot"}})
+	if _, ok := e.Fields[BootIndexField]; ok {
+		t.Error("boot index added without a boot id")
+	}
+}
+
+func TestIdentityStageWithoutPasswd(t *testing.T) {
+	root, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config := NewIdentityConfig()
+	config.Enabled = true
+	config.Root = root
+	if _, err := NewIdentityStage(config); err == nil {
+		t.Fatal("missing passwd accepted")
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"sync"
+)
+
+// inflight tracks the batches being published concurrently. It bounds how
+// many of them are in flight, keeps batches sharing an ordering key from
+// overtaking each other and advances the cursor only over the contiguous
+// prefix of acknowledged entries.
+type inflight struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int
+
+	// Number of batches in flight, queued ones included, and of running ones
+	// including each key.
+	n    int
+	keys map[string]int
+
+	// Batches waiting for others sharing a key with them, in order.
+	queue []*inflightBatch
+
+	// Sequence number of the oldest unacknowledged batch and of the next one,
+	// and the last cursor of acknowledged batches waiting for older ones.
+	oldest uint64
+	next   uint64
+	acked  map[uint64]string
+
+	// Number of times the cursor advanced.
+	advances uint64
+}
+
+type inflightBatch struct {
+	seq  uint64
+	keys []string
+	run  func(seq uint64)
+}
+
+// inflightCursor is a cursor of the acknowledged prefix, numbered in the
+// order the prefix grew.
+type inflightCursor struct {
+	cursor string
+	n      uint64
+}
+
+func newInflight(max int) *inflight {
+	if max < 1 {
+		max = 1
+	}
+	t := &inflight{
+		max:   max,
+		keys:  make(map[string]int),
+		acked: make(map[uint64]string),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// start waits for a free slot for a new batch including keys, and calls run
+// in its own goroutine with the sequence number of the batch. Batches sharing
+// any of keys with others in flight are queued instead of waited for, and run
+// once those are released.
+func (t *inflight) start(keys []string, run func(seq uint64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.n >= t.max {
+		t.cond.Wait()
+	}
+
+	t.n++
+	t.queue = append(t.queue, &inflightBatch{seq: t.next, keys: keys, run: run})
+	t.next++
+	t.dispatch()
+}
+
+// dispatch runs the queued batches which neither share a key with a running
+// batch nor with a batch queued before them.
+func (t *inflight) dispatch() {
+	waiting := make(map[string]bool)
+	queue := t.queue[:0]
+	for _, b := range t.queue {
+		if t.conflicts(b.keys, waiting) {
+			for _, key := range b.keys {
+				waiting[key] = true
+			}
+			queue = append(queue, b)
+			continue
+		}
+
+		for _, key := range b.keys {
+			t.keys[key]++
+		}
+		go func(b *inflightBatch) {
+			defer t.release(b.keys)
+			b.run(b.seq)
+		}(b)
+	}
+	for i := len(queue); i < len(t.queue); i++ {
+		t.queue[i] = nil
+	}
+	t.queue = queue
+}
+
+func (t *inflight) conflicts(keys []string, waiting map[string]bool) bool {
+	for _, key := range keys {
+		if t.keys[key] > 0 || waiting[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// ack records that the entries of batch seq up to cursor were published, and
+// whether that was the whole batch. It returns the cursor of the contiguous
+// acknowledged prefix if it moved. Cursors are handed over by concurrent
+// batches once the lock is released, so they may arrive out of order: only
+// the one numbered last is the latest.
+func (t *inflight) ack(seq uint64, cursor string, done bool) (inflightCursor, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if seq != t.oldest {
+		// Older batches are still in flight, wait for them.
+		if done {
+			t.acked[seq] = cursor
+		}
+		return inflightCursor{}, false
+	}
+
+	if done {
+		for {
+			t.oldest++
+			next, ok := t.acked[t.oldest]
+			if !ok {
+				break
+			}
+			delete(t.acked, t.oldest)
+			cursor = next
+		}
+	}
+	t.advances++
+	return inflightCursor{cursor: cursor, n: t.advances}, true
+}
+
+// release frees the slot and keys taken by a batch, once it's acknowledged
+// or abandoned, running the queued batches it held back.
+func (t *inflight) release(keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.n--
+	for _, key := range keys {
+		if t.keys[key]--; t.keys[key] == 0 {
+			delete(t.keys, key)
+		}
+	}
+	t.dispatch()
+	t.cond.Broadcast()
+}
+
+// wait blocks until no batch is in flight or queued.
+func (t *inflight) wait() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.n > 0 {
+		t.cond.Wait()
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflightQueuesConflictingBatches(t *testing.T) {
+	tr := newInflight(3)
+	releasec := make(chan bool)
+	runc := make(chan string, 3)
+	run := func(name string, block bool) func(uint64) {
+		return func(uint64) {
+			runc <- name
+			if block {
+				<-releasec
+			}
+		}
+	}
+
+	tr.start([]string{"a"}, run("a1", true))
+	if name := <-runc; name != "a1" {
+		t.Fatalf("%s ran first", name)
+	}
+
+	// Neither waits for a1, but only b1 runs.
+	startedc := make(chan bool)
+	go func() {
+		tr.start([]string{"a"}, run("a2", false))
+		tr.start([]string{"b"}, run("b1", false))
+		close(startedc)
+	}()
+	select {
+	case <-startedc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("start waited for a conflicting batch")
+	}
+	if name := <-runc; name != "b1" {
+		t.Fatalf("%s ran before a1 was released", name)
+	}
+
+	close(releasec)
+	if name := <-runc; name != "a2" {
+		t.Fatalf("%s ran instead of a2", name)
+	}
+	tr.wait()
+}
+
+func TestInflightKeepsQueuedBatchesInOrder(t *testing.T) {
+	tr := newInflight(4)
+	releasec := make(chan bool)
+	var mu sync.Mutex
+	var order []string
+	run := func(name string) func(uint64) {
+		return func(uint64) {
+			if name == "a1" {
+				<-releasec
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	// ab1 also waits for the batch of b, a2 must not overtake it once a1 is
+	// done.
+	tr.start([]string{"a"}, run("a1"))
+	tr.start([]string{"b"}, func(uint64) { time.Sleep(50 * time.Millisecond) })
+	tr.start([]string{"a", "b"}, run("ab1"))
+	tr.start([]string{"a"}, run("a2"))
+	close(releasec)
+	tr.wait()
+
+	assertMessages(t, order, "a1", "ab1", "a2")
+}
+
+func TestInflightBlocksWithoutFreeSlots(t *testing.T) {
+	tr := newInflight(1)
+	releasec := make(chan bool)
+	tr.start(nil, func(uint64) { <-releasec })
+
+	startedc := make(chan bool)
+	go func() {
+		tr.start(nil, func(uint64) {})
+		close(startedc)
+	}()
+	select {
+	case <-startedc:
+		t.Fatal("start didn't wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(releasec)
+	<-startedc
+	tr.wait()
+}
+
+func TestInflightAdvancesOverAcknowledgedPrefix(t *testing.T) {
+	tr := newInflight(3)
+	seqs := make(chan uint64, 3)
+	releasec := make(chan bool)
+	for i := 0; i < 3; i++ {
+		tr.start(nil, func(seq uint64) {
+			seqs <- seq
+			<-releasec
+		})
+	}
+	for i := 0; i < 3; i++ {
+		<-seqs
+	}
+
+	if _, ok := tr.ack(1, "b", true); ok {
+		t.Fatal("advanced past an unacknowledged batch")
+	}
+	c, ok := tr.ack(0, "a1", false)
+	if !ok || c.cursor != "a1" {
+		t.Fatalf("got %v, %v after a partial ack", c, ok)
+	}
+	last, ok := tr.ack(0, "a2", true)
+	if !ok || last.cursor != "b" || last.n <= c.n {
+		t.Fatalf("got %v, %v once the prefix was acknowledged", last, ok)
+	}
+	if _, ok := tr.ack(2, "c", false); !ok {
+		t.Fatal("didn't advance over the oldest batch")
+	}
+
+	close(releasec)
+	tr.wait()
+}
+
+// TestInflightConcurrentAcks acknowledges batches from their own goroutines,
+// handing cursors over after ack returns as the forwarder does: the one
+// numbered last must be the cursor of the last batch.
+func TestInflightConcurrentAcks(t *testing.T) {
+	const batches = 200
+	tr := newInflight(8)
+
+	var mu sync.Mutex
+	var latest inflightCursor
+	for i := 0; i < batches; i++ {
+		tr.start([]string{fmt.Sprint(i % 5)}, func(seq uint64) {
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			c, ok := tr.ack(seq, fmt.Sprint(seq), true)
+			if !ok {
+				return
+			}
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			mu.Lock()
+			if c.n > latest.n {
+				latest = c
+			}
+			mu.Unlock()
+		})
+	}
+	tr.wait()
+
+	if want := fmt.Sprint(batches - 1); latest.cursor != want {
+		t.Fatalf("latest cursor is %s, want %s", latest.cursor, want)
+	}
+}
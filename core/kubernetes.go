@@ -0,0 +1,261 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// Fields added to entries of kubernetes containers.
+	KubernetesNamespaceField    = "kubernetes.namespace"
+	KubernetesPodField          = "kubernetes.pod"
+	KubernetesContainerField    = "kubernetes.container"
+	KubernetesPodUIDField       = "kubernetes.pod_uid"
+	KubernetesLabelsPrefix      = "kubernetes.labels."
+	KubernetesAnnotationsPrefix = "kubernetes.annotations."
+)
+
+// KubernetesConfig represents options to drive the behavior of a KubernetesStage.
+type KubernetesConfig struct {
+	Enabled bool
+
+	// If not empty pod labels and annotations are fetched from this API
+	// server...
+	APIServer string
+
+	// ...or from this kubelet, which lists the pods of its node.
+	Kubelet string
+
+	// Credentials to talk to the API server or the kubelet.
+	TokenFile string
+	CAFile    string
+
+	// Name of the node the forwarder runs on, used to only watch its pods.
+	NodeName string
+
+	// Watch the API server for pod changes instead of just caching.
+	Watch bool
+
+	// How long pod metadata is cached for.
+	CacheTTL time.Duration
+
+	// Timeout of requests to the API server or the kubelet.
+	Timeout time.Duration
+}
+
+// NewKubernetesConfig creates a KubernetesConfig using the service account
+// credentials of the pod the forwarder runs in.
+func NewKubernetesConfig() KubernetesConfig {
+	return KubernetesConfig{
+		TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		CAFile:    "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+		CacheTTL:  5 * time.Minute,
+		Timeout:   5 * time.Second,
+	}
+}
+
+// IsZero reports whether kubernetes enrichment is disabled.
+func (c KubernetesConfig) IsZero() bool {
+	return !c.Enabled
+}
+
+// KubernetesStage adds the pod identity to entries written by containers run
+// by kubernetes through the docker journald log driver, whose CONTAINER_NAME
+// looks like k8s_<container>_<pod>_<namespace>_<uid>_<attempt>. Optionally,
+// pod labels and annotations are fetched from the API server or the kubelet.
+// They're fetched in the background: entries logged before a pod is known
+// only get its identity.
+type KubernetesStage struct {
+	api     *metadataClient
+	kubelet bool
+	ttl     time.Duration
+	pods    *metadataCache
+}
+
+type kubernetesPod struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		UID         string            `json:"uid"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+type kubernetesPodList struct {
+	Items []*kubernetesPod `json:"items"`
+}
+
+type kubernetesWatchEvent struct {
+	Type   string         `json:"type"`
+	Object *kubernetesPod `json:"object"`
+}
+
+// NewKubernetesStage creates a KubernetesStage as described by config.
+func NewKubernetesStage(config KubernetesConfig) (*KubernetesStage, error) {
+	s := &KubernetesStage{
+		ttl: config.CacheTTL,
+	}
+
+	var endpoint string
+	switch {
+	case config.APIServer != "":
+		endpoint = strings.TrimSuffix(config.APIServer, "/")
+	case config.Kubelet != "":
+		endpoint = strings.TrimSuffix(config.Kubelet, "/")
+		s.kubelet = true
+	default:
+		// Only what's in CONTAINER_NAME is available.
+		return s, nil
+	}
+
+	var token *Secret
+	if config.TokenFile != "" {
+		var err error
+		token, err = NewSecret("", config.TokenFile)
+		if err != nil && !strings.HasPrefix(endpoint, "http:") {
+			return nil, err
+		}
+	}
+
+	httpConfig := newMetadataHTTPConfig(config.Timeout)
+	if strings.HasPrefix(endpoint, "https:") {
+		httpConfig.CAFile = config.CAFile
+	}
+	transport, err := newHTTPTransport(httpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s.api = newMetadataClient(endpoint, transport, config.Timeout)
+	s.api.authorize = func(req *http.Request) {
+		if t := token.Value(); t != "" {
+			req.Header.Set("Authorization", "Bearer "+t)
+		}
+	}
+	s.pods = newMetadataCache(config.CacheTTL, s.fetch)
+
+	if config.Watch && !s.kubelet {
+		path := "/api/v1/pods?watch=true"
+		if config.NodeName != "" {
+			path += "&fieldSelector=" + url.QueryEscape("spec.nodeName="+config.NodeName)
+		}
+		go s.api.watch(path, s.handleEvent)
+	}
+
+	return s, nil
+}
+
+func (s *KubernetesStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	container, pod, namespace, uid, ok := parseKubernetesContainerName(e.Fields["CONTAINER_NAME"])
+	if !ok {
+		emit(e)
+		return
+	}
+
+	e.Fields[KubernetesNamespaceField] = namespace
+	e.Fields[KubernetesPodField] = pod
+	e.Fields[KubernetesContainerField] = container
+	e.Fields[KubernetesPodUIDField] = uid
+
+	if s.pods != nil {
+		if p, _ := s.pods.get(namespace + "/" + pod).(*kubernetesPod); p != nil {
+			for k, v := range p.Metadata.Labels {
+				e.Fields[KubernetesLabelsPrefix+dedot(k)] = v
+			}
+			for k, v := range p.Metadata.Annotations {
+				e.Fields[KubernetesAnnotationsPrefix+dedot(k)] = v
+			}
+		}
+	}
+
+	emit(e)
+}
+
+func (s *KubernetesStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// Close stops fetching pods and watching the API server.
+func (s *KubernetesStage) Close() error {
+	if s.api == nil {
+		return nil
+	}
+	s.pods.Close()
+	return s.api.Close()
+}
+
+// fetch gets the metadata of the pod identified by namespace/name. The kubelet
+// lists every pod of the node at once, all of them are cached.
+func (s *KubernetesStage) fetch(key string) (interface{}, error) {
+	if !s.kubelet {
+		namespace, name := splitKubernetesKey(key)
+		pod := &kubernetesPod{}
+		path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(name)
+		if err := s.api.get(path, pod); err != nil {
+			return nil, err
+		}
+		return pod, nil
+	}
+
+	list := &kubernetesPodList{}
+	if err := s.api.get("/pods", list); err != nil {
+		return nil, err
+	}
+	var pod *kubernetesPod
+	for _, p := range list.Items {
+		k := p.Metadata.Namespace + "/" + p.Metadata.Name
+		if k == key {
+			pod = p
+		} else {
+			s.pods.set(k, p, s.ttl)
+		}
+	}
+	return pod, nil
+}
+
+// handleEvent keeps the cache up to date with a pod change.
+func (s *KubernetesStage) handleEvent(decoder *json.Decoder) error {
+	var event kubernetesWatchEvent
+	if err := decoder.Decode(&event); err != nil {
+		return err
+	}
+	if event.Object == nil {
+		return nil
+	}
+
+	key := event.Object.Metadata.Namespace + "/" + event.Object.Metadata.Name
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		// Watched pods are kept up to date, don't expire them.
+		s.pods.set(key, event.Object, 24*time.Hour)
+	case "DELETED":
+		s.pods.invalidate(func(k string) bool { return k == key })
+	}
+	return nil
+}
+
+func splitKubernetesKey(key string) (string, string) {
+	i := strings.Index(key, "/")
+	return key[:i], key[i+1:]
+}
+
+// parseKubernetesContainerName splits the name docker containers are given by
+// the kubelet: k8s_<container>_<pod>_<namespace>_<uid>_<attempt>.
+func parseKubernetesContainerName(name string) (container, pod, namespace, uid string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "_")
+	if len(parts) != 6 || parts[0] != "k8s" {
+		return "", "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], parts[4], true
+}
+
+// dedot replaces dots in label and annotation keys so they aren't taken as
+// nested fields.
+func dedot(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
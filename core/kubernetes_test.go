@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const testKubernetesContainerName = "k8s_app_web-1_default_0123-4567_0"
+
+func newTestKubernetesPod(namespace, name string, labels map[string]string) *kubernetesPod {
+	p := &kubernetesPod{}
+	p.Metadata.Namespace = namespace
+	p.Metadata.Name = name
+	p.Metadata.Labels = labels
+	return p
+}
+
+func newTestKubernetesEntry() *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{
+		Fields: map[string]string{"CONTAINER_NAME": testKubernetesContainerName},
+	}
+}
+
+// processUntil runs fresh entries through s until one has field, as metadata
+// is fetched in the background.
+func processUntil(t *testing.T, s Stage, newEntry func() *sdjournal.JournalEntry, field string) *sdjournal.JournalEntry {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got *sdjournal.JournalEntry
+		s.Process(newEntry(), func(e *sdjournal.JournalEntry) { got = e })
+		if _, ok := got.Fields[field]; ok {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never added, got %v", field, got.Fields)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestKubernetesStage(t *testing.T, config KubernetesConfig) *KubernetesStage {
+	config.Enabled = true
+	config.TokenFile = ""
+	s, err := NewKubernetesStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestKubernetesStageWithoutAPI(t *testing.T) {
+	s := newTestKubernetesStage(t, NewKubernetesConfig())
+	defer s.Close()
+
+	var got *sdjournal.JournalEntry
+	s.Process(newTestKubernetesEntry(), func(e *sdjournal.JournalEntry) { got = e })
+	want := map[string]string{
+		KubernetesNamespaceField: "default",
+		KubernetesPodField:       "web-1",
+		KubernetesContainerField: "app",
+		KubernetesPodUIDField:    "0123-4567",
+	}
+	for k, v := range want {
+		if got.Fields[k] != v {
+			t.Errorf("%s is %q, want %q", k, got.Fields[k], v)
+		}
+	}
+}
+
+func TestKubernetesStageFetchesFromAPIServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/web-1" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(newTestKubernetesPod("default", "web-1", map[string]string{"app.kubernetes.io/name": "web"}))
+	}))
+	defer server.Close()
+
+	config := NewKubernetesConfig()
+	config.APIServer = server.URL
+	s := newTestKubernetesStage(t, config)
+	defer s.Close()
+
+	e := processUntil(t, s, newTestKubernetesEntry, KubernetesLabelsPrefix+"app_kubernetes_io/name")
+	if v := e.Fields[KubernetesLabelsPrefix+"app_kubernetes_io/name"]; v != "web" {
+		t.Fatalf("label is %q", v)
+	}
+}
+
+func TestKubernetesStageFetchesFromKubelet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(&kubernetesPodList{Items: []*kubernetesPod{
+			newTestKubernetesPod("default", "web-0", map[string]string{"app": "other"}),
+			newTestKubernetesPod("default", "web-1", map[string]string{"app": "web"}),
+		}})
+	}))
+	defer server.Close()
+
+	config := NewKubernetesConfig()
+	config.Kubelet = server.URL
+	s := newTestKubernetesStage(t, config)
+	defer s.Close()
+
+	e := processUntil(t, s, newTestKubernetesEntry, KubernetesLabelsPrefix+"app")
+	if v := e.Fields[KubernetesLabelsPrefix+"app"]; v != "web" {
+		t.Fatalf("label is %q", v)
+	}
+
+	// The other pods of the node were listed at once.
+	if p, _ := s.pods.get("default/web-0").(*kubernetesPod); p == nil || p.Metadata.Labels["app"] != "other" {
+		t.Fatalf("other pod not cached: %v", p)
+	}
+}
+
+func TestKubernetesStageWatchesAPIServer(t *testing.T) {
+	eventc := make(chan *kubernetesWatchEvent)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" {
+			// Only the watch knows about pods.
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("fieldSelector") != "spec.nodeName=node-1" {
+			t.Errorf("unexpected field selector %q", r.URL.Query().Get("fieldSelector"))
+		}
+		encoder := json.NewEncoder(w)
+		for {
+			select {
+			case event := <-eventc:
+				encoder.Encode(event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	config := NewKubernetesConfig()
+	config.APIServer = server.URL
+	config.NodeName = "node-1"
+	config.Watch = true
+	s := newTestKubernetesStage(t, config)
+
+	eventc <- &kubernetesWatchEvent{"ADDED", newTestKubernetesPod("default", "web-1", map[string]string{"app": "web"})}
+	e := processUntil(t, s, newTestKubernetesEntry, KubernetesLabelsPrefix+"app")
+	if v := e.Fields[KubernetesLabelsPrefix+"app"]; v != "web" {
+		t.Fatalf("label is %q", v)
+	}
+
+	eventc <- &kubernetesWatchEvent{"MODIFIED", newTestKubernetesPod("default", "web-1", map[string]string{"version": "2"})}
+	processUntil(t, s, newTestKubernetesEntry, KubernetesLabelsPrefix+"version")
+
+	// Closing the stage ends the watch, letting the server shut down.
+	s.Close()
+}
+
+func TestKubernetesStageDoesntWaitForSlowAPIServer(t *testing.T) {
+	releasec := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-releasec
+		json.NewEncoder(w).Encode(newTestKubernetesPod("default", "web-1", map[string]string{"app": "web"}))
+	}))
+	defer server.Close()
+	defer close(releasec)
+
+	config := NewKubernetesConfig()
+	config.APIServer = server.URL
+	s := newTestKubernetesStage(t, config)
+	defer s.Close()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		var got *sdjournal.JournalEntry
+		s.Process(newTestKubernetesEntry(), func(e *sdjournal.JournalEntry) { got = e })
+		if got == nil || got.Fields[KubernetesPodField] != "web-1" {
+			t.Fatalf("entry wasn't emitted with the pod identity: %v", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("processing waited for the API server for %v", elapsed)
+	}
+}
@@ -27,10 +27,116 @@ func Main(mainConfig MainConfig) {
 
 	// Define flag sets
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	fs.StringVar(&fc.Path, "path", fc.Path, "journal path.")
-	fs.StringVar(&fc.CursorPath, "cursor-path", fc.CursorPath, "cursor path.")
+	fs.StringVar(&fc.Source, "source", fc.Source, "source of entries: journal, export (journal export format), json (journalctl -o json) or remote (systemd-journal-upload receiver).")
+	fs.StringSliceVar(&fc.Paths, "path", fc.Paths, "journal paths, entries from all of them are merged.")
+	fs.StringSliceVar(&fc.Files, "file", fc.Files, "journal files to read, in addition to --path.")
+	fs.StringSliceVar(&fc.Namespaces, "namespace", fc.Namespaces, "journal namespaces to read, in addition to --path.")
+	fs.StringVar(&fc.Input, "input", fc.Input, "file to read export/json entries from, - for stdin.")
+	fs.StringVar(&fc.Remote.ListenAddr, "remote-listen", fc.Remote.ListenAddr, "address to accept remote journal uploads on.")
+	fs.StringVar(&fc.Remote.CertFile, "remote-cert", fc.Remote.CertFile, "certificate to serve remote journal uploads over https.")
+	fs.StringVar(&fc.Remote.KeyFile, "remote-key", fc.Remote.KeyFile, "private key of the remote journal uploads certificate.")
+	fs.StringVar(&fc.Remote.TrustedCAFile, "remote-trusted-ca", fc.Remote.TrustedCAFile, "ca bundle remote journal uploaders must present a certificate from.")
+	fs.DurationVar(&fc.Remote.ReadHeaderTimeout, "remote-read-header-timeout", fc.Remote.ReadHeaderTimeout, "maximum time to read the headers of a remote journal upload.")
+	fs.DurationVar(&fc.Remote.ReadTimeout, "remote-read-timeout", fc.Remote.ReadTimeout, "maximum time to read a whole remote journal upload, 0 means no limit.")
+	fs.DurationVar(&fc.Remote.IdleTimeout, "remote-idle-timeout", fc.Remote.IdleTimeout, "time idle remote journal uploaders are kept connected.")
+	fs.StringVar(&fc.CursorPath, "cursor-path", fc.CursorPath, "cursor path, suffixed with the source name and input by default for sources other than journal.")
 	fs.DurationVar(&fc.CursorFlush, "cursor-flush", fc.CursorFlush, "cursor flush frequency.")
-	fs.DurationVar(&fc.ForwardFlush, "forward-flush", fc.ForwardFlush, "forward flush frequency.")
+	fs.DurationVar(&fc.Batch.MaxAge, "forward-flush", fc.Batch.MaxAge, "maximum time entries wait in a batch before it's forwarded.")
+	fs.IntVar(&fc.Batch.MaxEntries, "batch-max-entries", fc.Batch.MaxEntries, "maximum number of entries of a batch.")
+	fs.IntVar(&fc.Batch.MaxBytes, "batch-max-bytes", fc.Batch.MaxBytes, "maximum size of a batch once encoded, 0 means no limit.")
+	fs.IntVar(&fc.InFlight, "in-flight", fc.InFlight, "maximum number of batches being published at once.")
+	fs.StringSliceVar(&fc.OrderKeys, "order-key", fc.OrderKeys, "fields identifying streams whose entries must be published in order, e.g. _SYSTEMD_UNIT.")
+	fs.DurationVar(&fc.StageFlush, "stage-flush", fc.StageFlush, "frequency at which processing stages flush held back entries.")
+
+	// Processing stages
+	fs.StringVar(&fc.Pipeline.Multiline.Start, "multiline-start", fc.Pipeline.Multiline.Start, "regular expression matching the first line of multiline entries.")
+	fs.StringVar(&fc.Pipeline.Multiline.Continue, "multiline-continue", fc.Pipeline.Multiline.Continue, "regular expression matching continuation lines of multiline entries.")
+	fs.StringSliceVar(&fc.Pipeline.Multiline.Keys, "multiline-key", fc.Pipeline.Multiline.Keys, "fields identifying the stream multiline entries are joined within.")
+	fs.DurationVar(&fc.Pipeline.Multiline.Timeout, "multiline-timeout", fc.Pipeline.Multiline.Timeout, "time to wait for more lines before emitting a multiline entry.")
+	fs.IntVar(&fc.Pipeline.Multiline.MaxLines, "multiline-max-lines", fc.Pipeline.Multiline.MaxLines, "maximum number of lines of a multiline entry.")
+	fs.BoolVar(&fc.Pipeline.Dedup.Enabled, "dedup", fc.Pipeline.Dedup.Enabled, "collapse repeated entries into one carrying REPEAT_COUNT.")
+	fs.StringSliceVar(&fc.Pipeline.Dedup.Keys, "dedup-key", fc.Pipeline.Dedup.Keys, "fields identifying the streams duplicates are looked for within.")
+	fs.StringVar(&fc.Pipeline.Dedup.Field, "dedup-field", fc.Pipeline.Dedup.Field, "field compared to detect duplicates.")
+	fs.DurationVar(&fc.Pipeline.Dedup.Window, "dedup-window", fc.Pipeline.Dedup.Window, "maximum time duplicates are collapsed for.")
+	fs.BoolVar(&fc.Pipeline.Dedup.Consecutive, "dedup-consecutive", fc.Pipeline.Dedup.Consecutive, "only collapse consecutive duplicates.")
+	fs.Float64Var(&fc.Pipeline.RateLimit.Rate, "rate-limit", fc.Pipeline.RateLimit.Rate, "entries per second allowed for each --rate-limit-key stream, 0 means no limit.")
+	fs.IntVar(&fc.Pipeline.RateLimit.Burst, "rate-limit-burst", fc.Pipeline.RateLimit.Burst, "entries allowed in a burst over --rate-limit.")
+	fs.StringSliceVar(&fc.Pipeline.RateLimit.Keys, "rate-limit-key", fc.Pipeline.RateLimit.Keys, "fields identifying rate limited streams.")
+	fs.StringSliceVar(&fc.Pipeline.RateLimit.Sample, "sample", fc.Pipeline.RateLimit.Sample, "ratio of entries to keep by priority, as PRIORITY=RATIO, e.g. 7=0.1.")
+	fs.StringVar(&fc.Pipeline.RateLimit.SampleMode, "sample-mode", fc.Pipeline.RateLimit.SampleMode, "sampling mode: random or hash (deterministic, by cursor).")
+	fs.DurationVar(&fc.Pipeline.RateLimit.SummaryInterval, "rate-limit-summary", fc.Pipeline.RateLimit.SummaryInterval, "how often summaries of suppressed entries are emitted.")
+	fs.StringSliceVar(&fc.Pipeline.Parse.Formats, "parse-format", fc.Pipeline.Parse.Formats, "structured payload formats to try parsing, in order: json, logfmt and/or regex.")
+	fs.StringVar(&fc.Pipeline.Parse.Field, "parse-field", fc.Pipeline.Parse.Field, "field holding the structured payload.")
+	fs.StringSliceVar(&fc.Pipeline.Parse.Regexes, "parse-regex", fc.Pipeline.Parse.Regexes, "regular expressions with named captures for the regex format.")
+	fs.StringVar(&fc.Pipeline.Parse.Prefix, "parse-prefix", fc.Pipeline.Parse.Prefix, "prefix for the name of parsed fields.")
+	fs.StringVar(&fc.Pipeline.Parse.Target, "parse-target", fc.Pipeline.Parse.Target, "prefix parsed fields with this name and a dot, nesting them under an object with --expand-dots.")
+	fs.BoolVar(&fc.Pipeline.Kubernetes.Enabled, "kubernetes", fc.Pipeline.Kubernetes.Enabled, "add kubernetes pod identity to entries of kubernetes containers.")
+	fs.StringVar(&fc.Pipeline.Kubernetes.APIServer, "kubernetes-api-server", fc.Pipeline.Kubernetes.APIServer, "api server to fetch pod labels and annotations from, e.g. https://kubernetes.default.svc.")
+	fs.StringVar(&fc.Pipeline.Kubernetes.Kubelet, "kubernetes-kubelet", fc.Pipeline.Kubernetes.Kubelet, "kubelet to fetch pod labels and annotations from, e.g. https://127.0.0.1:10250.")
+	fs.StringVar(&fc.Pipeline.Kubernetes.TokenFile, "kubernetes-token-file", fc.Pipeline.Kubernetes.TokenFile, "bearer token to authenticate against kubernetes.")
+	fs.StringVar(&fc.Pipeline.Kubernetes.CAFile, "kubernetes-ca-file", fc.Pipeline.Kubernetes.CAFile, "ca bundle to verify kubernetes against.")
+	fs.StringVar(&fc.Pipeline.Kubernetes.NodeName, "kubernetes-node-name", fc.Pipeline.Kubernetes.NodeName, "name of the node, to only watch its pods.")
+	fs.BoolVar(&fc.Pipeline.Kubernetes.Watch, "kubernetes-watch", fc.Pipeline.Kubernetes.Watch, "watch the api server for pod changes.")
+	fs.DurationVar(&fc.Pipeline.Kubernetes.CacheTTL, "kubernetes-cache-ttl", fc.Pipeline.Kubernetes.CacheTTL, "how long pod metadata is cached for.")
+	fs.BoolVar(&fc.Pipeline.Docker.Enabled, "docker", fc.Pipeline.Docker.Enabled, "add image and labels to entries of docker containers.")
+	fs.StringVar(&fc.Pipeline.Docker.Socket, "docker-socket", fc.Pipeline.Docker.Socket, "docker engine api socket.")
+	fs.DurationVar(&fc.Pipeline.Docker.CacheTTL, "docker-cache-ttl", fc.Pipeline.Docker.CacheTTL, "how long container metadata is cached for.")
+	fs.BoolVar(&fc.Pipeline.Docker.Events, "docker-events", fc.Pipeline.Docker.Events, "invalidate cached container metadata from docker events.")
+	fs.BoolVar(&fc.Pipeline.Host.Enabled, "host", fc.Pipeline.Host.Enabled, "add hostname, machine id, os and kernel information to every entry.")
+	fs.StringVar(&fc.Pipeline.Host.Root, "host-root", fc.Pipeline.Host.Root, "root of the host file system, to read /etc/machine-id and /etc/os-release from.")
+	fs.StringVar(&fc.Pipeline.Host.Cloud, "host-cloud", fc.Pipeline.Host.Cloud, "cloud to add instance metadata from: auto, ec2, gce or azure.")
+	fs.StringVar(&fc.Pipeline.Host.CloudEndpoint, "host-cloud-endpoint", fc.Pipeline.Host.CloudEndpoint, "instance metadata service endpoint.")
+	fs.DurationVar(&fc.Pipeline.Host.Timeout, "host-cloud-timeout", fc.Pipeline.Host.Timeout, "timeout of instance metadata requests.")
+	fs.StringSliceVar(&fc.Pipeline.Host.Tags, "tag", fc.Pipeline.Host.Tags, "static tags added to every entry, as KEY=VALUE.")
+	fs.BoolVar(&fc.Pipeline.Identity.Enabled, "identity", fc.Pipeline.Identity.Enabled, "resolve uids and gids to user and group names, and boot ids to boot indexes.")
+	fs.StringVar(&fc.Pipeline.Identity.Root, "identity-root", fc.Pipeline.Identity.Root, "root of the host file system, to read /etc/passwd and /etc/group from.")
+	fs.DurationVar(&fc.Pipeline.Identity.Reload, "identity-reload", fc.Pipeline.Identity.Reload, "minimum time between reloads of passwd and group on unknown ids.")
+	fs.StringSliceVar(&fc.Pipeline.Transform.Copy, "copy-field", fc.Pipeline.Transform.Copy, "copy fields, as SRC=DST.")
+	fs.StringSliceVar(&fc.Pipeline.Transform.Rename, "rename-field", fc.Pipeline.Transform.Rename, "rename fields, as OLD=NEW.")
+	fs.StringSliceVar(&fc.Pipeline.Transform.Drop, "drop-field", fc.Pipeline.Transform.Drop, "drop fields matching these glob patterns, e.g. _SOURCE_*.")
+	fs.StringSliceVar(&fc.Pipeline.Transform.Add, "add-field", fc.Pipeline.Transform.Add, "add static fields, as KEY=VALUE.")
+	fs.StringSliceVar(&fc.Pipeline.Transform.Coerce, "coerce-field", fc.Pipeline.Transform.Coerce, "coerce field types, as FIELD=TYPE with TYPE one of int, float, bool, string or rfc3339.")
+	fs.StringSliceVar(&fc.Pipeline.Redact.Detectors, "redact", fc.Pipeline.Redact.Detectors, "sensitive data to redact: creditcard, email, ipv4, ipv6, bearer, aws and/or jwt.")
+	fs.StringSliceVar(&fc.Pipeline.Redact.Regexes, "redact-regex", fc.Pipeline.Redact.Regexes, "additional regular expressions to redact.")
+	fs.StringSliceVar(&fc.Pipeline.Redact.Fields, "redact-field", fc.Pipeline.Redact.Fields, "fields to redact, glob patterns are allowed.")
+	fs.StringVar(&fc.Pipeline.Redact.Action, "redact-action", fc.Pipeline.Redact.Action, "what to do with sensitive data: mask, hash (keyed hmac) or drop the entry.")
+	fs.StringVar(&fc.Pipeline.Redact.Mask, "redact-mask", fc.Pipeline.Redact.Mask, "replacement for masked sensitive data.")
+	SecretVar(fs, &fc.Pipeline.Redact.HashKey, &fc.Pipeline.Redact.HashKeyFile, "redact-hash-key", "key for hashed sensitive data.")
+
+	// Marshalling
+	mc := NewMarshallerConfig()
+	fs.BoolVar(&mc.SortKeys, "sort-keys", mc.SortKeys, "encode fields in name order.")
+	fs.BoolVar(&mc.ExpandDots, "expand-dots", mc.ExpandDots, "encode dotted field names (e.g. kubernetes.pod) as nested objects.")
+	fs.BoolVar(&mc.TypedFields, "typed-fields", mc.TypedFields, "encode known numeric journal fields (PRIORITY, _PID, _UID...) as numbers.")
+	fs.StringVar(&mc.BinaryEncoding, "binary-encoding", mc.BinaryEncoding, "encoding of non utf-8 field values: replace, base64 or array (like journalctl -o json).")
+	fs.StringVar(&mc.CursorField, "cursor-field", mc.CursorField, "name of the cursor field, - to leave it out.")
+	fs.StringVar(&mc.RealtimeField, "realtime-field", mc.RealtimeField, "name of the realtime timestamp field, - to leave it out.")
+	fs.StringVar(&mc.MonotonicField, "monotonic-field", mc.MonotonicField, "name of the monotonic timestamp field, - to leave it out.")
+	fs.StringVar(&mc.Profile, "profile", mc.Profile, "schema to map fields onto: ecs (elastic common schema) or otel (opentelemetry log records).")
+	fs.StringVar(&mc.Namespace, "profile-namespace", mc.Namespace, "namespace fields not mapped by --profile are moved under.")
+
+	// HTTP providers
+	hc := NewHTTPConfig()
+	fs.StringVar(&hc.Compression, "http-compression", hc.Compression, "compression of http request bodies: "+compressionNames()+", if supported by the endpoint.")
+	fs.IntVar(&hc.CompressionLevel, "http-compression-level", hc.CompressionLevel, "compression level, 0 for the default of the algorithm.")
+	fs.DurationVar(&hc.Timeout, "http-timeout", hc.Timeout, "timeout of http requests, response included.")
+	fs.DurationVar(&hc.DialTimeout, "http-dial-timeout", hc.DialTimeout, "timeout of http connection establishment.")
+	fs.DurationVar(&hc.TLSHandshakeTimeout, "http-tls-handshake-timeout", hc.TLSHandshakeTimeout, "timeout of tls handshakes.")
+	fs.StringVar(&hc.CAFile, "http-ca-file", hc.CAFile, "ca bundle to verify http endpoints against, instead of the system one.")
+	fs.StringVar(&hc.CertFile, "http-cert-file", hc.CertFile, "client certificate for mutual tls.")
+	fs.StringVar(&hc.KeyFile, "http-key-file", hc.KeyFile, "client certificate private key for mutual tls.")
+	fs.StringVar(&hc.TLSMinVersion, "http-tls-min-version", hc.TLSMinVersion, "minimum tls version: 1.0, 1.1, 1.2 or 1.3.")
+	fs.StringVar(&hc.Proxy, "http-proxy", hc.Proxy, "proxy url, https_proxy/http_proxy/no_proxy are honored if empty.")
+	fs.DurationVar(&hc.KeepAlive, "http-keep-alive", hc.KeepAlive, "keep-alive period of http connections.")
+	fs.IntVar(&hc.MaxIdleConnsPerHost, "http-max-idle-conns", hc.MaxIdleConnsPerHost, "idle http connections kept per host, raised to --in-flight unless given.")
+	fs.DurationVar(&hc.IdleConnTimeout, "http-idle-conn-timeout", hc.IdleConnTimeout, "how long idle http connections are kept.")
+	fs.BoolVar(&hc.DisableKeepAlives, "http-disable-keep-alives", hc.DisableKeepAlives, "use a new connection per http request.")
+
+	// Rendering, for line oriented outputs
+	rc := NewRendererConfig()
+	fs.StringVar(&rc.Format, "output", rc.Format, "output format of line oriented providers: json, short, short-iso, short-precise, verbose, cat, with-unit or template.")
+	fs.StringVar(&rc.Template, "output-template", rc.Template, "go text/template of the template output format, e.g. '{{.Time.Format \"15:04:05\"}} {{.Fields.MESSAGE}}'.")
+	fs.BoolVar(&rc.UTC, "output-utc", rc.UTC, "render timestamps in utc instead of local time.")
 
 	// If provider has custom flags, append them
 	if mainConfig.Flags != nil {
@@ -74,12 +180,37 @@ func Main(mainConfig MainConfig) {
 		}
 	})
 
+	// Never exceed what the provider can take
+	if limiter, ok := mainConfig.ProviderConfig.(BatchLimiter); ok {
+		fc.Batch = fc.Batch.limit(limiter.BatchLimits())
+	}
+
+	if _, err := ParseBinaryEncoding(mc.BinaryEncoding); err != nil {
+		log.Fatalf("error parsing flags: %v", err)
+	}
+	if _, err := ParseProfile(mc.Profile); err != nil {
+		log.Fatalf("error parsing flags: %v", err)
+	}
+
+	// Every batch in flight may need a connection of its own
+	if !fs.Changed("http-max-idle-conns") && hc.MaxIdleConnsPerHost < fc.InFlight {
+		hc.MaxIdleConnsPerHost = fc.InFlight
+	}
+
+	// Stages talking to metadata APIs share the transport settings
+	DefaultHTTPConfig = hc
+
 	// Create forwarder
 	f, err := NewForwarder(fc)
 	if err != nil {
 		log.Fatalf("error creating forwarder: %v", err)
 	}
 
+	// Let marshallers know about coerced fields
+	mc.FieldTypes = f.FieldTypes()
+	DefaultMarshallerConfig = mc
+	DefaultRendererConfig = rc
+
 	// Create provider
 	p, err := mainConfig.Provider(mainConfig.ProviderConfig)
 	if err != nil {
@@ -94,12 +225,12 @@ func Main(mainConfig MainConfig) {
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	for {
 		select {
-		case err := <-f.errc:
+		case err := <-f.Errors():
 			os.Stderr.Write([]byte(err.Error()))
 		case s := <-signalChan:
 			log.Print(fmt.Sprintf("Captured %v. Exiting...", s))
-			close(f.stopc)
-		case <-f.donec:
+			f.Stop()
+		case <-f.Done():
 			os.Exit(0)
 		}
 	}
@@ -2,7 +2,12 @@ package core
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/glerchundi/go-systemd/sdjournal"
@@ -99,10 +104,199 @@ func (b *Buffer) Rewind(n int) {
 	b.Truncate(b.Len()-n)
 }
 
+// Field types
+
+// FieldType tells marshallers how to encode the value of a field.
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldInt
+	FieldFloat
+	FieldBool
+	// FieldTime is a microsecond timestamp encoded as an RFC3339 string.
+	FieldTime
+)
+
+// ParseFieldType parses the name of a FieldType.
+func ParseFieldType(name string) (FieldType, error) {
+	switch name {
+	case "string":
+		return FieldString, nil
+	case "int":
+		return FieldInt, nil
+	case "float":
+		return FieldFloat, nil
+	case "bool":
+		return FieldBool, nil
+	case "rfc3339":
+		return FieldTime, nil
+	}
+	return FieldString, fmt.Errorf("unknown field type: %s", name)
+}
+
+// KnownFieldTypes are the types of the numeric fields systemd documents,
+// used by marshallers with TypedFields set.
+var KnownFieldTypes = map[string]FieldType{
+	"PRIORITY":                    FieldInt,
+	"SYSLOG_FACILITY":             FieldInt,
+	"SYSLOG_PID":                  FieldInt,
+	"ERRNO":                       FieldInt,
+	"CODE_LINE":                   FieldInt,
+	"_PID":                        FieldInt,
+	"_TID":                        FieldInt,
+	"_UID":                        FieldInt,
+	"_GID":                        FieldInt,
+	"_AUDIT_SESSION":              FieldInt,
+	"_AUDIT_LOGINUID":             FieldInt,
+	"_SYSTEMD_OWNER_UID":          FieldInt,
+	"_SOURCE_REALTIME_TIMESTAMP":  FieldInt,
+	"_SOURCE_MONOTONIC_TIMESTAMP": FieldInt,
+	"OBJECT_PID":                  FieldInt,
+	"OBJECT_UID":                  FieldInt,
+	"OBJECT_GID":                  FieldInt,
+	"COREDUMP_PID":                FieldInt,
+	"COREDUMP_UID":                FieldInt,
+	"COREDUMP_GID":                FieldInt,
+	"COREDUMP_SIGNAL":             FieldInt,
+}
+
+// Encodings of field values which aren't valid UTF-8.
+const (
+	// BinaryReplace replaces invalid bytes with U+FFFD.
+	BinaryReplace = "replace"
+	// BinaryBase64 encodes the value as a base64 string.
+	BinaryBase64 = "base64"
+	// BinaryArray encodes the value as an array of bytes, like journalctl -o
+	// json does.
+	BinaryArray = "array"
+)
+
+// OmitField is the name given to header fields which shouldn't be encoded.
+const OmitField = "-"
+
 // Marshaller
 
+// MarshallerConfig represents options to drive the behavior of a
+// JournalEntryMarshaller.
+type MarshallerConfig struct {
+	// Types of the fields which shouldn't be encoded as strings.
+	FieldTypes map[string]FieldType
+
+	// Encode the numeric fields in KnownFieldTypes as numbers too.
+	TypedFields bool
+
+	// Encode dotted field names (e.g. "data.user.id") as nested objects.
+	ExpandDots bool
+
+	// Encode fields in name order instead of a random one.
+	SortKeys bool
+
+	// Encoding of field values which aren't valid UTF-8: replace, base64 or
+	// array.
+	BinaryEncoding string
+
+	// Names of the cursor and timestamp fields, OmitField to leave them out.
+	// Profiles name them on their own.
+	CursorField    string
+	RealtimeField  string
+	MonotonicField string
+
+	// Schema entries are mapped onto: ecs, otel or empty to keep journal
+	// field names.
+	Profile string
+
+	// Namespace fields not mapped by the profile are moved under.
+	Namespace string
+}
+
+// NewMarshallerConfig creates a MarshallerConfig encoding entries like
+// journalctl -o json names them.
+func NewMarshallerConfig() MarshallerConfig {
+	return MarshallerConfig{
+		BinaryEncoding: BinaryReplace,
+		CursorField:    "__CURSOR",
+		RealtimeField:  "__REALTIME_TIMESTAMP",
+		MonotonicField: "__MONOTONIC_TIMESTAMP",
+		Namespace:      "journald",
+	}
+}
+
+// ParseBinaryEncoding checks name is a known binary encoding.
+func ParseBinaryEncoding(name string) (string, error) {
+	switch name {
+	case BinaryReplace, BinaryBase64, BinaryArray:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown binary encoding: %s", name)
+}
+
+// withDefaults fills the options left unset in c with their defaults.
+func (c MarshallerConfig) withDefaults() MarshallerConfig {
+	defaults := NewMarshallerConfig()
+	if c.BinaryEncoding == "" {
+		c.BinaryEncoding = defaults.BinaryEncoding
+	}
+	if c.CursorField == "" {
+		c.CursorField = defaults.CursorField
+	}
+	if c.RealtimeField == "" {
+		c.RealtimeField = defaults.RealtimeField
+	}
+	if c.MonotonicField == "" {
+		c.MonotonicField = defaults.MonotonicField
+	}
+	return c
+}
+
+// fieldTypes returns the types of the fields which aren't encoded as strings.
+// Explicitly coerced fields take precedence over known ones.
+func (c MarshallerConfig) fieldTypes() map[string]FieldType {
+	if !c.TypedFields {
+		return c.FieldTypes
+	}
+	types := make(map[string]FieldType, len(KnownFieldTypes)+len(c.FieldTypes))
+	for k, t := range KnownFieldTypes {
+		types[k] = t
+	}
+	for k, t := range c.FieldTypes {
+		types[k] = t
+	}
+	return types
+}
+
+// DefaultMarshallerConfig is used by marshallers created with
+// NewJournalEntryMarshaller. It's set up by Main from the command line.
+var DefaultMarshallerConfig = NewMarshallerConfig()
+
+// JournalEntryMarshaller encodes entries as JSON. The slices it returns are
+// overwritten by the next call, use a StreamEncoder to share one between
+// goroutines.
 type JournalEntryMarshaller struct {
-	buf Buffer
+	buf     Buffer
+	config  MarshallerConfig
+	types   map[string]FieldType
+	profile *fieldProfile
+	keys    []string
+}
+
+// NewJournalEntryMarshaller creates a JournalEntryMarshaller configured with
+// DefaultMarshallerConfig.
+func NewJournalEntryMarshaller() *JournalEntryMarshaller {
+	return NewJournalEntryMarshallerWithConfig(DefaultMarshallerConfig)
+}
+
+// NewJournalEntryMarshallerWithConfig creates a JournalEntryMarshaller
+// configured with config.
+func NewJournalEntryMarshallerWithConfig(config MarshallerConfig) *JournalEntryMarshaller {
+	config = config.withDefaults()
+	types := config.fieldTypes()
+
+	m := &JournalEntryMarshaller{config: config, types: types}
+	if m.profile = newFieldProfile(config.Profile, config.Namespace, types); m.profile != nil {
+		m.types = m.profile.types
+	}
+	return m
 }
 
 func (m *JournalEntryMarshaller) MarshalOne(e *sdjournal.JournalEntry) []byte {
@@ -125,6 +319,32 @@ func (m *JournalEntryMarshaller) MarshalAll(ea []*sdjournal.JournalEntry) []byte
 	return m.buf.Bytes()
 }
 
+func (m *JournalEntryMarshaller) batchStart(n int) []byte {
+	m.buf.Reset()
+	m.buf.WriteByte('[')
+	return m.buf.Bytes()
+}
+
+func (m *JournalEntryMarshaller) batchEntry(i int, e *sdjournal.JournalEntry) []byte {
+	m.buf.Reset()
+	if i > 0 {
+		m.buf.WriteByte(',')
+	}
+	m.marshalOne(e)
+	return m.buf.Bytes()
+}
+
+func (m *JournalEntryMarshaller) batchEnd() []byte {
+	m.buf.Reset()
+	m.buf.WriteByte(']')
+	return m.buf.Bytes()
+}
+
+// ContentType returns the media type of marshalled entries.
+func (m *JournalEntryMarshaller) ContentType() string {
+	return "application/json"
+}
+
 func (m *JournalEntryMarshaller) Bytes() []byte {
 	return m.buf.Bytes()
 }
@@ -134,21 +354,197 @@ func (m *JournalEntryMarshaller) String() string {
 }
 
 func (m *JournalEntryMarshaller) marshalOne(e *sdjournal.JournalEntry) {
-	m.buf.WriteString(`{"__CURSOR":`)
-	m.buf.WriteJsonString(e.Cursor)
-	m.buf.WriteString(`,"__REALTIME_TIMESTAMP":`)
-	m.buf.WriteUint(uint64(e.RealtimeTimestamp))
-	m.buf.WriteString(`,"__MONOTONIC_TIMESTAMP":`)
-	m.buf.WriteUint(uint64(e.MonotonicTimestamp))
-	if e.Fields != nil {
+	switch m.config.Profile {
+	case ProfileECS:
+		m.marshalECS(e)
+		return
+	case ProfileOTel:
+		m.marshalOTel(e)
+		return
+	}
+
+	m.buf.WriteByte('{')
+	start := m.buf.Len()
+	if m.config.CursorField != OmitField {
+		m.buf.WriteJsonString(m.config.CursorField)
+		m.buf.WriteByte(':')
+		m.buf.WriteJsonString(e.Cursor)
+		m.buf.WriteByte(',')
+	}
+	if m.config.RealtimeField != OmitField {
+		m.buf.WriteJsonString(m.config.RealtimeField)
+		m.buf.WriteByte(':')
+		m.writeTimestamp("__REALTIME_TIMESTAMP", e.RealtimeTimestamp)
+		m.buf.WriteByte(',')
+	}
+	if m.config.MonotonicField != OmitField {
+		m.buf.WriteJsonString(m.config.MonotonicField)
+		m.buf.WriteByte(':')
+		m.writeTimestamp("__MONOTONIC_TIMESTAMP", e.MonotonicTimestamp)
 		m.buf.WriteByte(',')
+	}
+	if m.config.ExpandDots {
+		m.writeNestedFields(e.Fields)
+	} else if m.config.SortKeys {
+		m.keys = m.keys[:0]
+		for key := range e.Fields {
+			m.keys = append(m.keys, key)
+		}
+		sort.Strings(m.keys)
+		for _, key := range m.keys {
+			m.writeField(key, e.Fields[key])
+		}
+	} else {
 		for key, value := range e.Fields {
-			m.buf.WriteJsonString(key)
-			m.buf.WriteString(`:`)
-			m.buf.WriteJsonString(string(value))
-			m.buf.WriteByte(',')
+			m.writeField(key, value)
 		}
+	}
+	if m.buf.Len() > start {
 		m.buf.Rewind(1)
 	}
 	m.buf.WriteByte('}')
+}
+
+// writeField writes a key/value pair followed by a comma.
+func (m *JournalEntryMarshaller) writeField(key string, value string) {
+	m.buf.WriteJsonString(key)
+	m.buf.WriteByte(':')
+	m.writeValue(key, value)
+	m.buf.WriteByte(',')
+}
+
+// fieldNode is a level of a dotted field name hierarchy.
+type fieldNode struct {
+	key      string
+	value    *string
+	names    []string
+	children map[string]*fieldNode
+}
+
+func (n *fieldNode) child(name string) *fieldNode {
+	if n.children == nil {
+		n.children = make(map[string]*fieldNode)
+	}
+	c, ok := n.children[name]
+	if !ok {
+		c = &fieldNode{}
+		n.children[name] = c
+		n.names = append(n.names, name)
+	}
+	return c
+}
+
+// writeNestedFields writes fields turning dotted names into nested objects,
+// in a stable order.
+func (m *JournalEntryMarshaller) writeNestedFields(fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	root := &fieldNode{}
+	for _, key := range keys {
+		n := root
+		for _, name := range strings.Split(key, ".") {
+			n = n.child(name)
+		}
+		value := fields[key]
+		n.key, n.value = key, &value
+	}
+
+	for _, name := range root.names {
+		m.writeNode(name, root.children[name])
+		m.buf.WriteByte(',')
+	}
+}
+
+func (m *JournalEntryMarshaller) writeNode(name string, n *fieldNode) {
+	if n.value != nil {
+		m.buf.WriteJsonString(name)
+		m.buf.WriteString(`:`)
+		m.writeValue(n.key, *n.value)
+		if len(n.names) == 0 {
+			return
+		}
+		// Both a value and nested fields, keep the latter flattened.
+		m.writeFlatNodes(name, n)
+		return
+	}
+
+	m.buf.WriteJsonString(name)
+	m.buf.WriteString(`:{`)
+	for i, child := range n.names {
+		if i > 0 {
+			m.buf.WriteByte(',')
+		}
+		m.writeNode(child, n.children[child])
+	}
+	m.buf.WriteByte('}')
+}
+
+// writeFlatNodes writes the fields nested under n next to it, named relative
+// to the object n is in, prefix being the name of n in there.
+func (m *JournalEntryMarshaller) writeFlatNodes(prefix string, n *fieldNode) {
+	for _, name := range n.names {
+		c := n.children[name]
+		key := prefix + "." + name
+		if c.value != nil {
+			m.buf.WriteByte(',')
+			m.buf.WriteJsonString(key)
+			m.buf.WriteString(`:`)
+			m.writeValue(c.key, *c.value)
+		}
+		m.writeFlatNodes(key, c)
+	}
+}
+
+func (m *JournalEntryMarshaller) writeTimestamp(key string, usec uint64) {
+	if m.types[key] == FieldTime {
+		m.buf.WriteJsonString(FormatUsec(usec))
+		return
+	}
+	m.buf.WriteUint(usec)
+}
+
+// writeValue writes value according to the type of the field, falling back to
+// a string if it isn't a valid one.
+func (m *JournalEntryMarshaller) writeValue(key string, value string) {
+	switch m.types[key] {
+	case FieldInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			m.buf.WriteString(value)
+			return
+		}
+	case FieldFloat:
+		if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			m.buf.Write(strconv.AppendFloat(m.buf.scratch[:0], f, 'g', -1, 64))
+			return
+		}
+	case FieldBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			m.buf.WriteString(strconv.FormatBool(b))
+			return
+		}
+	}
+	if !utf8.ValidString(value) {
+		switch m.config.BinaryEncoding {
+		case BinaryBase64:
+			m.buf.WriteByte('"')
+			m.buf.WriteString(base64.StdEncoding.EncodeToString([]byte(value)))
+			m.buf.WriteByte('"')
+			return
+		case BinaryArray:
+			m.buf.WriteByte('[')
+			for i := 0; i < len(value); i++ {
+				if i > 0 {
+					m.buf.WriteByte(',')
+				}
+				m.buf.WriteUint(uint64(value[i]))
+			}
+			m.buf.WriteByte(']')
+			return
+		}
+	}
+	m.buf.WriteJsonString(value)
 }
\ No newline at end of file
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+func newTestMarshallerEntry(fields map[string]string) *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{
+		Cursor:             "s=1;i=2",
+		RealtimeTimestamp:  1483585445000007,
+		MonotonicTimestamp: 1500000,
+		Fields:             fields,
+	}
+}
+
+func marshal(config MarshallerConfig, e *sdjournal.JournalEntry) string {
+	return string(NewJournalEntryMarshallerWithConfig(config).MarshalOne(e))
+}
+
+func TestMarshallerSortKeys(t *testing.T) {
+	config := NewMarshallerConfig()
+	config.SortKeys = true
+	e := newTestMarshallerEntry(map[string]string{
+		"_PID":          "42",
+		"MESSAGE":       "hello",
+		"_SYSTEMD_UNIT": "ssh.service",
+		"PRIORITY":      "6",
+	})
+
+	want := `{"__CURSOR":"s=1;i=2","__REALTIME_TIMESTAMP":1483585445000007,"__MONOTONIC_TIMESTAMP":1500000,` +
+		`"MESSAGE":"hello","PRIORITY":"6","_PID":"42","_SYSTEMD_UNIT":"ssh.service"}`
+	for i := 0; i < 10; i++ {
+		if got := marshal(config, e); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestMarshallerTypedFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		typed  bool
+		types  map[string]FieldType
+		fields map[string]string
+		want   string
+	}{
+		{
+			name:   "untyped",
+			fields: map[string]string{"PRIORITY": "6"},
+			want:   `"PRIORITY":"6"`,
+		},
+		{
+			name:   "known",
+			typed:  true,
+			fields: map[string]string{"PRIORITY": "6"},
+			want:   `"PRIORITY":6`,
+		},
+		{
+			name:   "known invalid",
+			typed:  true,
+			fields: map[string]string{"_PID": "n/a"},
+			want:   `"_PID":"n/a"`,
+		},
+		{
+			name:   "coerced over known",
+			typed:  true,
+			types:  map[string]FieldType{"PRIORITY": FieldString},
+			fields: map[string]string{"PRIORITY": "6"},
+			want:   `"PRIORITY":"6"`,
+		},
+		{
+			name:   "float",
+			types:  map[string]FieldType{"LATENCY": FieldFloat},
+			fields: map[string]string{"LATENCY": "0.25"},
+			want:   `"LATENCY":0.25`,
+		},
+		{
+			name:   "float infinity",
+			types:  map[string]FieldType{"LATENCY": FieldFloat},
+			fields: map[string]string{"LATENCY": "+Inf"},
+			want:   `"LATENCY":"+Inf"`,
+		},
+		{
+			name:   "bool",
+			types:  map[string]FieldType{"OK": FieldBool},
+			fields: map[string]string{"OK": "1"},
+			want:   `"OK":true`,
+		},
+	}
+
+	for _, test := range tests {
+		config := NewMarshallerConfig()
+		config.TypedFields = test.typed
+		config.FieldTypes = test.types
+		config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+		if got := marshal(config, newTestMarshallerEntry(test.fields)); got != "{"+test.want+"}" {
+			t.Errorf("%s: got %s, want {%s}", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMarshallerTypedTimestamp(t *testing.T) {
+	config := NewMarshallerConfig()
+	config.FieldTypes = map[string]FieldType{"__REALTIME_TIMESTAMP": FieldTime}
+	config.CursorField, config.MonotonicField = OmitField, OmitField
+
+	want := `{"__REALTIME_TIMESTAMP":"2017-01-05T03:04:05.000007Z"}`
+	if got := marshal(config, newTestMarshallerEntry(nil)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshallerBinaryEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     string
+	}{
+		{BinaryReplace, `"DATA":"a\ufffdb\n"`},
+		{BinaryBase64, `"DATA":"Yf9iCg=="`},
+		{BinaryArray, `"DATA":[97,255,98,10]`},
+	}
+
+	for _, test := range tests {
+		config := NewMarshallerConfig()
+		config.BinaryEncoding = test.encoding
+		config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+		e := newTestMarshallerEntry(map[string]string{"DATA": "a\xffb\n"})
+		if got := marshal(config, e); got != "{"+test.want+"}" {
+			t.Errorf("%s: got %s, want {%s}", test.encoding, got, test.want)
+		}
+	}
+
+	// Valid UTF-8 is left alone.
+	config := NewMarshallerConfig()
+	config.BinaryEncoding = BinaryBase64
+	config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+	e := newTestMarshallerEntry(map[string]string{"MESSAGE": "café"})
+	if got, want := marshal(config, e), `{"MESSAGE":"café"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshallerHeaderFields(t *testing.T) {
+	tests := []struct {
+		cursor, realtime, monotonic string
+		want                        string
+	}{
+		{
+			"", "", "",
+			`{"__CURSOR":"s=1;i=2","__REALTIME_TIMESTAMP":1483585445000007,"__MONOTONIC_TIMESTAMP":1500000,"MESSAGE":"hello"}`,
+		},
+		{
+			"cursor", "@timestamp", "uptime",
+			`{"cursor":"s=1;i=2","@timestamp":1483585445000007,"uptime":1500000,"MESSAGE":"hello"}`,
+		},
+		{
+			OmitField, "time", OmitField,
+			`{"time":1483585445000007,"MESSAGE":"hello"}`,
+		},
+		{
+			OmitField, OmitField, OmitField,
+			`{"MESSAGE":"hello"}`,
+		},
+	}
+
+	for _, test := range tests {
+		config := NewMarshallerConfig()
+		config.CursorField, config.RealtimeField, config.MonotonicField = test.cursor, test.realtime, test.monotonic
+		e := newTestMarshallerEntry(map[string]string{"MESSAGE": "hello"})
+		if got := marshal(config, e); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	config := NewMarshallerConfig()
+	config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+	if got, want := marshal(config, newTestMarshallerEntry(nil)), `{}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshallerExpandDots(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{
+			map[string]string{"MESSAGE": "hello", "kubernetes.pod": "web-1", "kubernetes.namespace": "default"},
+			`{"MESSAGE":"hello","kubernetes":{"namespace":"default","pod":"web-1"}}`,
+		},
+		{
+			map[string]string{"a.b.c": "1", "a.d": "2"},
+			`{"a":{"b":{"c":"1"},"d":"2"}}`,
+		},
+		{
+			// Values with nested fields keep them next to them, relative to
+			// the object they're in.
+			map[string]string{"a.b": "1", "a.b.c": "2", "a.b.c.d": "3", "a.e": "4"},
+			`{"a":{"b":"1","b.c":"2","b.c.d":"3","e":"4"}}`,
+		},
+		{
+			map[string]string{"x": "1", "x.y.z": "2"},
+			`{"x":"1","x.y.z":"2"}`,
+		},
+	}
+
+	for _, test := range tests {
+		config := NewMarshallerConfig()
+		config.ExpandDots = true
+		config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+		if got := marshal(config, newTestMarshallerEntry(test.fields)); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	// Dotted names are kept as they are by default.
+	config := NewMarshallerConfig()
+	config.SortKeys = true
+	config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+	e := newTestMarshallerEntry(map[string]string{"kubernetes.pod": "web-1"})
+	if got, want := marshal(config, e), `{"kubernetes.pod":"web-1"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshallerExpandDotsTypedFields(t *testing.T) {
+	config := NewMarshallerConfig()
+	config.ExpandDots = true
+	config.FieldTypes = map[string]FieldType{"http.status": FieldInt, "http.status.ok": FieldBool}
+	config.CursorField, config.RealtimeField, config.MonotonicField = OmitField, OmitField, OmitField
+	e := newTestMarshallerEntry(map[string]string{"http.status": "200", "http.status.ok": "true"})
+
+	want := `{"http":{"status":200,"status.ok":true}}`
+	if got := marshal(config, e); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+var errMemorySourceClosed = errors.New("memory source closed")
+
+// MemorySource is an in-memory Source whose contents are scripted by the
+// caller. Entries, journal rotations, invalidations and read errors can be
+// injected at any time, which makes it suitable for deterministic tests of the
+// forwarding loop with any provider.
+type MemorySource struct {
+	mu      sync.Mutex
+	entries []*sdjournal.JournalEntry
+	next    int
+	last    string
+	errs    []error
+	seq     uint64
+	stale   bool
+	closed  bool
+	eventc  chan bool
+}
+
+// NewMemorySource creates an empty MemorySource.
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		eventc: make(chan bool, 1),
+	}
+}
+
+// Append adds entries to the tail of the source. Entries without cursor or
+// realtime timestamp get monotonically increasing ones assigned.
+func (s *MemorySource) Append(entries ...*sdjournal.JournalEntry) {
+	s.mu.Lock()
+	for _, e := range entries {
+		s.seq++
+		if e.Cursor == "" {
+			e.Cursor = fmt.Sprintf("s=memory;i=%x", s.seq)
+		}
+		if e.RealtimeTimestamp == 0 {
+			e.RealtimeTimestamp = s.seq
+		}
+		if e.MonotonicTimestamp == 0 {
+			e.MonotonicTimestamp = s.seq
+		}
+		s.entries = append(s.entries, e)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// AppendFields is a shorthand for appending entries made of the given fields.
+func (s *MemorySource) AppendFields(fields ...map[string]string) {
+	entries := make([]*sdjournal.JournalEntry, len(fields))
+	for i, f := range fields {
+		entries[i] = &sdjournal.JournalEntry{Fields: f}
+	}
+	s.Append(entries...)
+}
+
+// Rotate simulates a journal rotation followed by a vacuum: every entry which
+// was already read is discarded and an invalidation event is raised.
+func (s *MemorySource) Rotate() {
+	s.mu.Lock()
+	s.entries = append([]*sdjournal.JournalEntry(nil), s.entries[s.next:]...)
+	s.next = 0
+	s.stale = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Invalidate raises an invalidation event without changing the contents.
+func (s *MemorySource) Invalidate() {
+	s.mu.Lock()
+	s.stale = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Fail makes the next read return err instead of an entry.
+func (s *MemorySource) Fail(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// SeekCursor positions the source right after the entry pointed by cursor.
+func (s *MemorySource) SeekCursor(cursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.Cursor == cursor {
+			s.next = i + 1
+			s.last = cursor
+			return nil
+		}
+	}
+	return fmt.Errorf("cursor not found: %s", cursor)
+}
+
+// SeekRealtime positions the source at the first entry whose realtime
+// timestamp is equal or greater than t.
+func (s *MemorySource) SeekRealtime(t time.Time) error {
+	usec := uint64(t.UnixNano() / int64(time.Microsecond))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = len(s.entries)
+	for i, e := range s.entries {
+		if e.RealtimeTimestamp >= usec {
+			s.next = i
+			break
+		}
+	}
+	return nil
+}
+
+// Close closes the source, once read the pending entries Follow returns.
+func (s *MemorySource) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errMemorySourceClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+func (s *MemorySource) Follow(ctx context.Context,
+	recvc chan<- *sdjournal.JournalEntry,
+	errc chan<- error) {
+	for {
+		e, err := s.readEntry()
+		if err == errMemorySourceClosed {
+			return
+		}
+		if err != nil {
+			select {
+			case errc <- err:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if e != nil {
+			select {
+			case recvc <- e:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// We're at the tail, so wait for new events.
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.eventc:
+			s.reseek()
+		}
+	}
+}
+
+func (s *MemorySource) readEntry() (*sdjournal.JournalEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+
+	if s.next >= len(s.entries) {
+		if s.closed {
+			return nil, errMemorySourceClosed
+		}
+		return nil, nil
+	}
+
+	e := s.entries[s.next]
+	s.next++
+	s.last = e.Cursor
+	return e, nil
+}
+
+// reseek repositions the source after the last read entry if it was
+// invalidated, the same way a real journal has to be handled.
+func (s *MemorySource) reseek() {
+	s.mu.Lock()
+	last, stale := s.last, s.stale
+	s.stale = false
+	s.mu.Unlock()
+	if !stale || last == "" {
+		return
+	}
+
+	// If the entry is gone keep reading from wherever we are.
+	s.SeekCursor(last)
+}
+
+func (s *MemorySource) notify() {
+	select {
+	case s.eventc <- true:
+	default:
+	}
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// OriginField labels every entry read through a MergeSource with the
+	// origin it was read from.
+	OriginField = "JOURNAL_ORIGIN"
+
+	// DefaultMergeWindow is how long a MergeSource waits for an origin which
+	// has nothing to read before emitting entries from the others.
+	DefaultMergeWindow = 100 * time.Millisecond
+)
+
+// MergeSource merges several sources into a single one, interleaving their
+// entries in realtime timestamp order and labeling them with their origin.
+//
+// Its cursors encode the last cursor emitted for every origin, so resuming
+// positions each of the underlying sources where it was left.
+type MergeSource struct {
+	origins []string
+	sources []Source
+	cursors url.Values
+	window  time.Duration
+}
+
+type mergeItem struct {
+	index int
+	entry *sdjournal.JournalEntry
+}
+
+// NewMergeSource creates a MergeSource, origins[i] being the label of sources[i].
+func NewMergeSource(origins []string, sources []Source) (*MergeSource, error) {
+	if len(origins) != len(sources) {
+		return nil, errors.New("every source must have an origin")
+	}
+
+	return &MergeSource{
+		origins: origins,
+		sources: sources,
+		cursors: url.Values{},
+		window:  DefaultMergeWindow,
+	}, nil
+}
+
+// SeekCursor positions every source right after its part of the cursor.
+// Sources which are not part of it start from their current position.
+//
+// A plain journal cursor, persisted while a single journal was followed, can't
+// tell where the other sources were left, so every source is positioned at its
+// timestamp instead (forwarding entries logged at that same microsecond
+// again), or at the current time if it has none.
+func (s *MergeSource) SeekCursor(cursor string) error {
+	if strings.Contains(cursor, ";") {
+		t, ok := cursorRealtime(cursor)
+		if !ok {
+			t = time.Now()
+		}
+		return s.SeekRealtime(t)
+	}
+
+	cursors, err := url.ParseQuery(cursor)
+	if err != nil {
+		return err
+	}
+
+	for i, origin := range s.origins {
+		c := cursors.Get(origin)
+		if c == "" {
+			continue
+		}
+		if err := s.sources[i].SeekCursor(c); err != nil {
+			return err
+		}
+		s.cursors.Set(origin, c)
+	}
+
+	return nil
+}
+
+// cursorRealtime returns the realtime timestamp of a journal cursor, which
+// looks like s=<seqnum id>;i=<seqnum>;b=<boot id>;m=<monotonic>;t=<realtime>;x=<xor hash>.
+func cursorRealtime(cursor string) (time.Time, bool) {
+	for _, part := range strings.Split(cursor, ";") {
+		if !strings.HasPrefix(part, "t=") {
+			continue
+		}
+		usec, err := strconv.ParseUint(part[2:], 16, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(usec)*int64(time.Microsecond)), true
+	}
+	return time.Time{}, false
+}
+
+func (s *MergeSource) SeekRealtime(t time.Time) error {
+	for _, source := range s.sources {
+		if err := source.SeekRealtime(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MergeSource) Close() error {
+	var err error
+	for _, source := range s.sources {
+		if cerr := source.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *MergeSource) Follow(ctx context.Context,
+	recvc chan<- *sdjournal.JournalEntry,
+	errc chan<- error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := len(s.sources)
+	itemc := make(chan mergeItem)
+	nextc := make([]chan bool, n)
+
+	// Every source is followed on its own and hands over a single entry at a
+	// time, the next one isn't read until the previous one was merged.
+	for i, source := range s.sources {
+		nextc[i] = make(chan bool, 1)
+		go s.followOne(ctx, i, source, itemc, nextc[i], errc)
+	}
+
+	heads := make([]*sdjournal.JournalEntry, n)
+	done := make([]bool, n)
+	idle := make([]bool, n)
+	for {
+		// Emit the oldest entry unless some origin may still provide an older one.
+		min, ready, finished := -1, true, true
+		for i := range heads {
+			if heads[i] != nil {
+				finished = false
+				if min < 0 || heads[i].RealtimeTimestamp < heads[min].RealtimeTimestamp {
+					min = i
+				}
+			} else if !done[i] {
+				finished = false
+				if !idle[i] {
+					ready = false
+				}
+			}
+		}
+
+		if finished {
+			return
+		}
+
+		if min >= 0 && ready {
+			e := s.label(min, heads[min])
+			select {
+			case recvc <- e:
+			case <-ctx.Done():
+				return
+			}
+			heads[min] = nil
+			nextc[min] <- true
+			continue
+		}
+
+		// Wait for the missing origins, but not forever if others are ready.
+		var timeoutc <-chan time.Time
+		if min >= 0 {
+			timeoutc = time.After(s.window)
+		}
+
+		select {
+		case item := <-itemc:
+			if item.entry == nil {
+				done[item.index] = true
+			} else {
+				heads[item.index] = item.entry
+				idle[item.index] = false
+			}
+		case <-timeoutc:
+			for i := range heads {
+				if heads[i] == nil {
+					idle[i] = true
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *MergeSource) followOne(ctx context.Context,
+	index int,
+	source Source,
+	itemc chan<- mergeItem,
+	nextc <-chan bool,
+	errc chan<- error) {
+	entryc := make(chan *sdjournal.JournalEntry)
+	donec := make(chan bool)
+	go func() {
+		defer close(donec)
+		source.Follow(ctx, entryc, errc)
+	}()
+
+	for {
+		select {
+		case e := <-entryc:
+			select {
+			case itemc <- mergeItem{index, e}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-nextc:
+			case <-ctx.Done():
+				return
+			}
+		case <-donec:
+			select {
+			case itemc <- mergeItem{index, nil}:
+			case <-ctx.Done():
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// label tags e with its origin and replaces its cursor with one covering
+// every origin.
+func (s *MergeSource) label(index int, e *sdjournal.JournalEntry) *sdjournal.JournalEntry {
+	origin := s.origins[index]
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[OriginField] = origin
+
+	s.cursors.Set(origin, e.Cursor)
+	e.Cursor = s.cursors.Encode()
+	return e
+}
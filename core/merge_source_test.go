@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// newTestJournal creates a closed MemorySource whose entries have journal like
+// cursors and the given realtime timestamps, in microseconds.
+func newTestJournal(id string, timestamps ...uint64) *MemorySource {
+	s := NewMemorySource()
+	for i, t := range timestamps {
+		s.Append(&sdjournal.JournalEntry{
+			Cursor:            fmt.Sprintf("s=%s;i=%x;b=boot;m=%x;t=%x;x=0", id, i+1, t, t),
+			RealtimeTimestamp: t,
+			Fields:            map[string]string{"MESSAGE": fmt.Sprintf("%s%d", id, t)},
+		})
+	}
+	s.Close()
+	return s
+}
+
+func followAll(t *testing.T, s Source) []*sdjournal.JournalEntry {
+	recvc := make(chan *sdjournal.JournalEntry)
+	donec := make(chan bool)
+	go func() {
+		s.Follow(context.Background(), recvc, make(chan error, 10))
+		close(donec)
+	}()
+
+	var entries []*sdjournal.JournalEntry
+	for {
+		select {
+		case e := <-recvc:
+			entries = append(entries, e)
+		case <-donec:
+			return entries
+		case <-time.After(5 * time.Second):
+			t.Fatal("Follow didn't return")
+		}
+	}
+}
+
+func TestMergeSourceMergesInRealtimeOrder(t *testing.T) {
+	s, err := NewMergeSource([]string{"a", "b"}, []Source{
+		newTestJournal("a", 1, 3, 5),
+		newTestJournal("b", 2, 4, 6),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := followAll(t, s)
+	assertMessages(t, messagesOf(entries), "a1", "b2", "a3", "b4", "a5", "b6")
+	if origin := entries[1].Fields[OriginField]; origin != "b" {
+		t.Fatalf("origin is %q", origin)
+	}
+}
+
+func TestMergeSourceResumesFromMergedCursor(t *testing.T) {
+	s, _ := NewMergeSource([]string{"a", "b"}, []Source{
+		newTestJournal("a", 1, 3, 5),
+		newTestJournal("b", 2, 4, 6),
+	})
+	cursor := followAll(t, s)[2].Cursor
+
+	s, _ = NewMergeSource([]string{"a", "b"}, []Source{
+		newTestJournal("a", 1, 3, 5),
+		newTestJournal("b", 2, 4, 6),
+	})
+	if err := s.SeekCursor(cursor); err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, messagesOf(followAll(t, s)), "b4", "a5", "b6")
+}
+
+func TestMergeSourceSeeksPlainJournalCursor(t *testing.T) {
+	s, _ := NewMergeSource([]string{"a", "b"}, []Source{
+		newTestJournal("a", 1, 3, 5),
+		newTestJournal("b", 2, 4, 6),
+	})
+
+	// Persisted by a forwarder following "a" only
+	if err := s.SeekCursor("s=a;i=2;b=boot;m=3;t=3;x=0"); err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, messagesOf(followAll(t, s)), "a3", "b4", "a5", "b6")
+}
+
+func TestJournalSourceMergesFiles(t *testing.T) {
+	defer func(open func(JournalFollowerConfig) (Source, error)) { openJournal = open }(openJournal)
+	journals := map[string]*MemorySource{
+		"a.journal": newTestJournal("a", 1, 3),
+		"b.journal": newTestJournal("b", 2, 4),
+	}
+	openJournal = func(config JournalFollowerConfig) (Source, error) {
+		if len(config.Files) != 1 || config.Path != "" {
+			t.Fatalf("unexpected journal %+v", config)
+		}
+		return journals[config.Files[0]], nil
+	}
+
+	config := NewForwarderConfig(1)
+	config.Paths = nil
+	config.Files = []string{"a.journal", "b.journal"}
+	s, err := newJournalSource(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*MergeSource); !ok {
+		t.Fatalf("files weren't merged: %T", s)
+	}
+
+	entries := followAll(t, s)
+	assertMessages(t, messagesOf(entries), "a1", "b2", "a3", "b4")
+	if origin := entries[1].Fields[OriginField]; origin != "b.journal" {
+		t.Fatalf("origin is %q", origin)
+	}
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metadataClient talks to the JSON HTTP APIs enrichment stages get metadata
+// from, such as the kubernetes API server or the Docker Engine API.
+type metadataClient struct {
+	endpoint string
+
+	// Requests are timed out, watches are long lived.
+	client *http.Client
+	stream *http.Client
+
+	// If not nil, called to add credentials to every request.
+	authorize func(req *http.Request)
+
+	stopc chan bool
+}
+
+// newMetadataHTTPConfig returns the transport settings of metadata clients:
+// those of DefaultHTTPConfig, save for the ones tied to provider endpoints.
+func newMetadataHTTPConfig(timeout time.Duration) HTTPConfig {
+	config := DefaultHTTPConfig
+	config.Timeout = timeout
+	config.Compression, config.CompressionLevel = CompressionNone, 0
+	config.CAFile, config.CertFile, config.KeyFile = "", "", ""
+	return config
+}
+
+func newMetadataClient(endpoint string, transport http.RoundTripper, timeout time.Duration) *metadataClient {
+	return &metadataClient{
+		endpoint: endpoint,
+		client:   &http.Client{Transport: transport, Timeout: timeout},
+		stream:   &http.Client{Transport: transport},
+		stopc:    make(chan bool),
+	}
+}
+
+// get decodes the JSON document at path into v.
+func (c *metadataClient) get(path string, v interface{}) error {
+	res, err := c.do(c.client, path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (c *metadataClient) do(client *http.Client, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.authorize != nil {
+		c.authorize(req)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status from %s: %s", path, res.Status)
+	}
+	return res, nil
+}
+
+// watch passes the stream of JSON events at path to handle, one decoder per
+// connection, reconnecting whenever it breaks until the client is closed.
+func (c *metadataClient) watch(path string, handle func(decoder *json.Decoder) error) {
+	for {
+		c.watchOnce(path, handle)
+
+		select {
+		case <-c.stopc:
+			return
+		case <-time.After(followRetryInterval):
+		}
+	}
+}
+
+func (c *metadataClient) watchOnce(path string, handle func(decoder *json.Decoder) error) {
+	res, err := c.do(c.stream, path)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	// Unblock the decoder when the client is closed.
+	donec := make(chan bool)
+	defer close(donec)
+	go func() {
+		select {
+		case <-c.stopc:
+			res.Body.Close()
+		case <-donec:
+		}
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(res.Body))
+	for handle(decoder) == nil {
+	}
+}
+
+// Close stops watching.
+func (c *metadataClient) Close() error {
+	close(c.stopc)
+	return nil
+}
+
+// metadataCache holds metadata fetched in the background, so that stages
+// never wait for slow or unreachable APIs: entries are enriched with whatever
+// is cached, and missing or expired keys are fetched for the next ones.
+// Failed fetches keep serving the previous value until retried after ttl.
+type metadataCache struct {
+	fetch func(key string) (interface{}, error)
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	items map[string]*metadataItem
+	queue chan string
+	stopc chan bool
+}
+
+type metadataItem struct {
+	value    interface{}
+	expires  time.Time
+	fetching bool
+}
+
+// metadataQueueSize bounds the keys waiting to be fetched, the others are
+// asked for again with their next entry.
+const metadataQueueSize = 64
+
+func newMetadataCache(ttl time.Duration, fetch func(key string) (interface{}, error)) *metadataCache {
+	c := &metadataCache{
+		fetch: fetch,
+		ttl:   ttl,
+		items: make(map[string]*metadataItem),
+		queue: make(chan string, metadataQueueSize),
+		stopc: make(chan bool),
+	}
+	go c.run()
+	return c
+}
+
+// get returns the value cached for key, nil if there's none yet, and fetches
+// it in the background if it's missing or expired.
+func (c *metadataCache) get(key string) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item := c.items[key]
+	if item == nil {
+		item = &metadataItem{}
+		c.items[key] = item
+	}
+	if !item.fetching && !time.Now().Before(item.expires) {
+		select {
+		case c.queue <- key:
+			item.fetching = true
+		default:
+		}
+	}
+	return item.value
+}
+
+// set caches value for key for ttl.
+func (c *metadataCache) set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item := c.items[key]
+	if item == nil {
+		item = &metadataItem{}
+		c.items[key] = item
+	}
+	item.value = value
+	item.expires = time.Now().Add(ttl)
+}
+
+// invalidate forgets every key for which match returns true.
+func (c *metadataCache) invalidate(match func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.items {
+		if match(key) {
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *metadataCache) run() {
+	for {
+		select {
+		case key := <-c.queue:
+			value, err := c.fetch(key)
+
+			c.mu.Lock()
+			item := c.items[key]
+			if item == nil {
+				item = &metadataItem{}
+				c.items[key] = item
+			}
+			if err == nil {
+				item.value = value
+			}
+			item.expires = time.Now().Add(c.ttl)
+			item.fetching = false
+			c.mu.Unlock()
+		case <-c.stopc:
+			return
+		}
+	}
+}
+
+// Close stops fetching.
+func (c *metadataCache) Close() error {
+	close(c.stopc)
+	return nil
+}
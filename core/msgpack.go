@@ -0,0 +1,127 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// MsgpackEncoder encodes entries as MessagePack maps, and batches of them as
+// arrays. Values which aren't valid UTF-8 are encoded as bin.
+type MsgpackEncoder struct {
+	mapper  entryMapper
+	buf     []byte
+	scratch [8]byte
+}
+
+// NewMsgpackEncoder creates a MsgpackEncoder configured with config.
+func NewMsgpackEncoder(config MarshallerConfig) *MsgpackEncoder {
+	return &MsgpackEncoder{mapper: newEntryMapper(config)}
+}
+
+func (m *MsgpackEncoder) MarshalOne(e *sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.mapper.writeEntry(m, e)
+	return m.buf
+}
+
+func (m *MsgpackEncoder) MarshalAll(ea []*sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.writeLength(len(ea), 0x90, 16, 0xdc, 0xdd)
+	for _, e := range ea {
+		m.mapper.writeEntry(m, e)
+	}
+	return m.buf
+}
+
+func (m *MsgpackEncoder) batchStart(n int) []byte {
+	m.buf = m.buf[:0]
+	m.writeLength(n, 0x90, 16, 0xdc, 0xdd)
+	return m.buf
+}
+
+func (m *MsgpackEncoder) batchEntry(i int, e *sdjournal.JournalEntry) []byte {
+	return m.MarshalOne(e)
+}
+
+func (m *MsgpackEncoder) batchEnd() []byte {
+	return nil
+}
+
+func (m *MsgpackEncoder) ContentType() string {
+	return "application/msgpack"
+}
+
+// writeLength writes n using the fixed format if it fits, otherwise the 16 or
+// 32 bit ones.
+func (m *MsgpackEncoder) writeLength(n int, fix byte, fixMax int, b16, b32 byte) {
+	switch {
+	case n < fixMax:
+		m.buf = append(m.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		binary.BigEndian.PutUint16(m.scratch[:], uint16(n))
+		m.buf = append(append(m.buf, b16), m.scratch[:2]...)
+	default:
+		binary.BigEndian.PutUint32(m.scratch[:], uint32(n))
+		m.buf = append(append(m.buf, b32), m.scratch[:4]...)
+	}
+}
+
+func (m *MsgpackEncoder) writeMapHeader(n int) {
+	m.writeLength(n, 0x80, 16, 0xde, 0xdf)
+}
+
+func (m *MsgpackEncoder) writeString(s string) {
+	if len(s) >= 32 && len(s) <= math.MaxUint8 {
+		m.buf = append(m.buf, 0xd9, byte(len(s)))
+	} else {
+		m.writeLength(len(s), 0xa0, 32, 0xda, 0xdb)
+	}
+	m.buf = append(m.buf, s...)
+}
+
+func (m *MsgpackEncoder) writeBytes(b string) {
+	switch {
+	case len(b) <= math.MaxUint8:
+		m.buf = append(m.buf, 0xc4, byte(len(b)))
+	case len(b) <= math.MaxUint16:
+		binary.BigEndian.PutUint16(m.scratch[:], uint16(len(b)))
+		m.buf = append(append(m.buf, 0xc5), m.scratch[:2]...)
+	default:
+		binary.BigEndian.PutUint32(m.scratch[:], uint32(len(b)))
+		m.buf = append(append(m.buf, 0xc6), m.scratch[:4]...)
+	}
+	m.buf = append(m.buf, b...)
+}
+
+func (m *MsgpackEncoder) writeUint(v uint64) {
+	if v < 128 {
+		m.buf = append(m.buf, byte(v))
+		return
+	}
+	binary.BigEndian.PutUint64(m.scratch[:], v)
+	m.buf = append(append(m.buf, 0xcf), m.scratch[:8]...)
+}
+
+func (m *MsgpackEncoder) writeInt(v int64) {
+	if v >= -32 && v < 128 {
+		m.buf = append(m.buf, byte(v))
+		return
+	}
+	binary.BigEndian.PutUint64(m.scratch[:], uint64(v))
+	m.buf = append(append(m.buf, 0xd3), m.scratch[:8]...)
+}
+
+func (m *MsgpackEncoder) writeFloat(v float64) {
+	binary.BigEndian.PutUint64(m.scratch[:], math.Float64bits(v))
+	m.buf = append(append(m.buf, 0xcb), m.scratch[:8]...)
+}
+
+func (m *MsgpackEncoder) writeBool(v bool) {
+	if v {
+		m.buf = append(m.buf, 0xc3)
+	} else {
+		m.buf = append(m.buf, 0xc2)
+	}
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// MultilineConfig represents options to drive the behavior of a MultilineStage.
+type MultilineConfig struct {
+	// Entries matching Start begin a new group. If empty every entry does,
+	// unless it matches Continue.
+	Start string
+
+	// Entries matching Continue are appended to the current group. If empty
+	// every entry not matching Start is.
+	Continue string
+
+	// Fields identifying the stream entries are grouped within.
+	Keys []string
+
+	// Field whose values are joined.
+	Field string
+
+	// Groups are emitted once they don't grow for this long...
+	Timeout time.Duration
+
+	// ...or once they are made of this many entries.
+	MaxLines int
+}
+
+// NewMultilineConfig creates a MultilineConfig grouping MESSAGE per unit and
+// process.
+func NewMultilineConfig() MultilineConfig {
+	return MultilineConfig{
+		Keys:     []string{"_SYSTEMD_UNIT", "_PID"},
+		Field:    "MESSAGE",
+		Timeout:  1 * time.Second,
+		MaxLines: 500,
+	}
+}
+
+// IsZero reports whether neither start nor continuation patterns are set.
+func (c MultilineConfig) IsZero() bool {
+	return c.Start == "" && c.Continue == ""
+}
+
+// MultilineStage joins consecutive entries of the same stream, such as the
+// lines of a stack trace, into a single entry. The combined entry keeps the
+// fields and the cursor of the first one. The forwarder doesn't persist the
+// cursor past groups being held, even if entries of other streams are emitted
+// meanwhile.
+type MultilineStage struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	keys     []string
+	field    string
+	timeout  time.Duration
+	maxLines int
+	groups   map[string]*multilineGroup
+}
+
+type multilineGroup struct {
+	entry   *sdjournal.JournalEntry
+	lines   []string
+	updated time.Time
+}
+
+// NewMultilineStage creates a MultilineStage as described by config.
+func NewMultilineStage(config MultilineConfig) (*MultilineStage, error) {
+	if config.IsZero() {
+		return nil, errors.New("multiline requires a start or continue pattern")
+	}
+
+	s := &MultilineStage{
+		keys:     config.Keys,
+		field:    config.Field,
+		timeout:  config.Timeout,
+		maxLines: config.MaxLines,
+		groups:   make(map[string]*multilineGroup),
+	}
+
+	var err error
+	if config.Start != "" {
+		if s.start, err = regexp.Compile(config.Start); err != nil {
+			return nil, err
+		}
+	}
+	if config.Continue != "" {
+		if s.cont, err = regexp.Compile(config.Continue); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *MultilineStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	line, ok := e.Fields[s.field]
+	if !ok {
+		emit(e)
+		return
+	}
+
+	key := streamKey(e, s.keys)
+	group := s.groups[key]
+
+	if group != nil && s.continues(line) {
+		group.lines = append(group.lines, line)
+		group.updated = time.Now()
+		if s.maxLines > 0 && len(group.lines) >= s.maxLines {
+			s.emitGroup(key, emit)
+		}
+		return
+	}
+
+	if group != nil {
+		s.emitGroup(key, emit)
+	}
+
+	if !s.starts(line) {
+		emit(e)
+		return
+	}
+
+	s.groups[key] = &multilineGroup{
+		entry:   e,
+		lines:   []string{line},
+		updated: time.Now(),
+	}
+}
+
+func (s *MultilineStage) held(fn func(cursor string)) {
+	for _, group := range s.groups {
+		fn(group.entry.Cursor)
+	}
+}
+
+func (s *MultilineStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+	var expired []string
+	for key, group := range s.groups {
+		if now.IsZero() || now.Sub(group.updated) >= s.timeout {
+			expired = append(expired, key)
+		}
+	}
+
+	// Keep the original ordering among expired groups.
+	sort.Slice(expired, func(i, j int) bool {
+		return s.groups[expired[i]].entry.RealtimeTimestamp < s.groups[expired[j]].entry.RealtimeTimestamp
+	})
+	for _, key := range expired {
+		s.emitGroup(key, emit)
+	}
+}
+
+// starts reports whether line may begin a group.
+func (s *MultilineStage) starts(line string) bool {
+	if s.start != nil {
+		return s.start.MatchString(line)
+	}
+	return !s.cont.MatchString(line)
+}
+
+// continues reports whether line belongs to the group in progress.
+func (s *MultilineStage) continues(line string) bool {
+	if s.cont != nil {
+		return s.cont.MatchString(line)
+	}
+	return !s.start.MatchString(line)
+}
+
+func (s *MultilineStage) emitGroup(key string, emit func(*sdjournal.JournalEntry)) {
+	group := s.groups[key]
+	delete(s.groups, key)
+	group.entry.Fields[s.field] = strings.Join(group.lines, "\n")
+	emit(group.entry)
+}
+
+// streamKey identifies the stream an entry belongs to by the values of keys.
+func streamKey(e *sdjournal.JournalEntry, keys []string) string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = e.Fields[key]
+	}
+	return strings.Join(values, "\x00")
+}
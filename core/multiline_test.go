@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMultilineStage(t *testing.T, start string) *MultilineStage {
+	config := NewMultilineConfig()
+	config.Start = start
+	config.Continue = `^\s`
+	config.Keys = []string{"_SYSTEMD_UNIT"}
+	s, err := NewMultilineStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMultilineStageHoldsCursorWithInterleavedStreams(t *testing.T) {
+	r := newCheckpointRecorder(newTestMultilineStage(t, "^panic"))
+	r.process("a", "panic: boom")
+	r.process("b", "hello")
+	r.process("a", "  at main()")
+	r.process("b", "bye")
+	r.flush(time.Time{})
+
+	// Entries of other streams pass through, but the cursor mustn't move past
+	// the group until it's emitted.
+	r.assert(t,
+		checkpointed{"hello", "c2", ""},
+		checkpointed{"bye", "c4", ""},
+		checkpointed{"panic: boom\n  at main()", "c1", "c4"},
+	)
+}
+
+func TestMultilineStageEmitsGroupOnNextStart(t *testing.T) {
+	r := newCheckpointRecorder(newTestMultilineStage(t, ""))
+	r.process("a", "first")
+	r.process("a", "  continued")
+	r.process("a", "second")
+	r.process("b", "other")
+	r.flush(time.Time{})
+
+	r.assert(t,
+		checkpointed{"first\n  continued", "c1", "c2"},
+		checkpointed{"second", "c3", "c3"},
+		checkpointed{"other", "c4", "c4"},
+	)
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// MachineIDPath is where the machine id used to locate journal namespaces
+	// is read from.
+	MachineIDPath = "/etc/machine-id"
+
+	// journalRoots are the places where journald stores namespaced journals,
+	// persistent storage is preferred over volatile.
+	journalRoots = []string{"/var/log/journal", "/run/log/journal"}
+)
+
+// NamespacePath returns the directory where the journal of the given systemd
+// journal namespace lives: <root>/<machine-id>.<namespace>.
+func NamespacePath(namespace string) (string, error) {
+	data, err := ioutil.ReadFile(MachineIDPath)
+	if err != nil {
+		return "", err
+	}
+	machineID := strings.TrimSpace(string(data))
+
+	for _, root := range journalRoots {
+		path := filepath.Join(root, machineID+"."+namespace)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("journal namespace %s not found", namespace)
+}
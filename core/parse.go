@@ -0,0 +1,290 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	ParseFormatJSON   = "json"
+	ParseFormatLogfmt = "logfmt"
+	ParseFormatRegex  = "regex"
+
+	// ParseErrorField tags entries whose payload looked structured but
+	// couldn't be parsed.
+	ParseErrorField = "PARSE_ERROR"
+)
+
+// ParseConfig represents options to drive the behavior of a ParseStage.
+type ParseConfig struct {
+	// Formats to try, in order: json, logfmt and/or regex.
+	Formats []string
+
+	// Field holding the structured payload.
+	Field string
+
+	// Regular expressions with named captures used by the regex format.
+	Regexes []string
+
+	// Prefix prepended to the name of every extracted field.
+	Prefix string
+
+	// If not empty extracted fields are nested under an object with this name
+	// instead of being merged at the top level.
+	Target string
+}
+
+// NewParseConfig creates a ParseConfig parsing MESSAGE.
+func NewParseConfig() ParseConfig {
+	return ParseConfig{
+		Field: "MESSAGE",
+	}
+}
+
+// IsZero reports whether no format is configured.
+func (c ParseConfig) IsZero() bool {
+	return len(c.Formats) == 0
+}
+
+// ParseStage extracts structured data (JSON, logfmt or regular expression
+// named captures) out of a field and merges it into the entry. The original
+// field is always kept, and so are trusted fields (those starting with an
+// underscore, added by journald itself), so that applications can't spoof
+// their origin.
+type ParseStage struct {
+	formats []string
+	field   string
+	regexes []*regexp.Regexp
+	prefix  string
+}
+
+// NewParseStage creates a ParseStage as described by config.
+func NewParseStage(config ParseConfig) (*ParseStage, error) {
+	s := &ParseStage{
+		formats: config.Formats,
+		field:   config.Field,
+		prefix:  config.Prefix,
+	}
+	if config.Target != "" {
+		s.prefix = config.Target + "." + config.Prefix
+	}
+
+	for _, format := range config.Formats {
+		switch format {
+		case ParseFormatJSON, ParseFormatLogfmt:
+		case ParseFormatRegex:
+			if len(config.Regexes) == 0 {
+				return nil, errors.New("regex format requires at least one regex")
+			}
+		default:
+			return nil, fmt.Errorf("unknown parse format: %s", format)
+		}
+	}
+
+	for _, expr := range config.Regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		s.regexes = append(s.regexes, re)
+	}
+
+	return s, nil
+}
+
+func (s *ParseStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	value, ok := e.Fields[s.field]
+	if !ok {
+		emit(e)
+		return
+	}
+
+	var lastErr error
+	for _, format := range s.formats {
+		var fields map[string]string
+		var err error
+		switch format {
+		case ParseFormatJSON:
+			fields, err = parseJSON(value)
+		case ParseFormatLogfmt:
+			fields, err = parseLogfmt(value)
+		case ParseFormatRegex:
+			fields = s.parseRegex(value)
+		}
+
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", format, err)
+			continue
+		}
+		if fields != nil {
+			for k, v := range fields {
+				name := s.prefix + k
+				if strings.HasPrefix(name, "_") || name == s.field {
+					continue
+				}
+				e.Fields[name] = v
+			}
+			emit(e)
+			return
+		}
+	}
+
+	if lastErr != nil {
+		e.Fields[ParseErrorField] = lastErr.Error()
+	}
+	emit(e)
+}
+
+func (s *ParseStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+func (s *ParseStage) parseRegex(value string) map[string]string {
+	for _, re := range s.regexes {
+		match := re.FindStringSubmatch(value)
+		if match == nil {
+			continue
+		}
+		fields := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" && i < len(match) {
+				fields[name] = match[i]
+			}
+		}
+		return fields
+	}
+	return nil
+}
+
+// parseJSON parses a JSON object flattening nested ones into dotted keys.
+// It returns nil fields if value doesn't look like a JSON object.
+func parseJSON(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		return nil, nil
+	}
+
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	flattenJSON("", object, fields)
+	return fields, nil
+}
+
+func flattenJSON(prefix string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			flattenJSON(prefix+k+".", child, fields)
+		}
+		return
+	case nil:
+		return
+	case string:
+		fields[strings.TrimSuffix(prefix, ".")] = v
+	case json.Number:
+		fields[strings.TrimSuffix(prefix, ".")] = v.String()
+	case bool:
+		fields[strings.TrimSuffix(prefix, ".")] = strconv.FormatBool(v)
+	default:
+		// Arrays are kept as JSON.
+		data, _ := json.Marshal(v)
+		fields[strings.TrimSuffix(prefix, ".")] = string(data)
+	}
+}
+
+// parseLogfmt parses space separated key=value pairs, values being optionally
+// double quoted. It returns nil fields unless every token is a pair, so that
+// prose mentioning some key=value isn't taken as logfmt.
+func parseLogfmt(value string) (map[string]string, error) {
+	if !strings.Contains(value, "=") {
+		return nil, nil
+	}
+
+	fields := make(map[string]string)
+	pairs := 0
+	i, n := 0, len(value)
+	for i < n {
+		// Skip separators
+		for i < n && value[i] == ' ' {
+			i++
+		}
+		if i == n {
+			break
+		}
+
+		// Key
+		start := i
+		for i < n && value[i] != '=' && value[i] != ' ' {
+			if value[i] == '"' {
+				return nil, nil
+			}
+			i++
+		}
+		key := value[start:i]
+		if i == n || value[i] == ' ' || key == "" {
+			return nil, nil
+		}
+		i++ // '='
+
+		// Value
+		if i < n && value[i] == '"' {
+			var buf bytes.Buffer
+			i++
+			closed := false
+			for i < n {
+				c := value[i]
+				if c == '\\' && i+1 < n {
+					switch value[i+1] {
+					case 'n':
+						buf.WriteByte('\n')
+					case 't':
+						buf.WriteByte('\t')
+					default:
+						buf.WriteByte(value[i+1])
+					}
+					i += 2
+					continue
+				}
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+				buf.WriteByte(c)
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated quoted value for %s", key)
+			}
+			if i < n && value[i] != ' ' {
+				return nil, fmt.Errorf("unexpected character after quoted value for %s", key)
+			}
+			fields[key] = buf.String()
+		} else {
+			start = i
+			for i < n && value[i] != ' ' {
+				i++
+			}
+			fields[key] = value[start:i]
+		}
+		pairs++
+	}
+
+	if pairs == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+func parseEntry(t *testing.T, config ParseConfig, fields map[string]string) map[string]string {
+	s, err := NewParseStage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emitted []*sdjournal.JournalEntry
+	s.Process(&sdjournal.JournalEntry{Fields: fields}, func(e *sdjournal.JournalEntry) {
+		emitted = append(emitted, e)
+	})
+	if len(emitted) != 1 {
+		t.Fatalf("%d entries emitted", len(emitted))
+	}
+	return emitted[0].Fields
+}
+
+func TestParseStageKeepsTrustedFields(t *testing.T) {
+	config := NewParseConfig()
+	config.Formats = []string{ParseFormatJSON}
+	fields := parseEntry(t, config, map[string]string{
+		"MESSAGE":       `{"_PID":"1","_SYSTEMD_UNIT":"sshd.service","__CURSOR":"x","MESSAGE":"y","level":"info"}`,
+		"_PID":          "4242",
+		"_SYSTEMD_UNIT": "app.service",
+	})
+
+	if fields["_PID"] != "4242" || fields["_SYSTEMD_UNIT"] != "app.service" {
+		t.Fatalf("trusted fields were overwritten: %v", fields)
+	}
+	if _, ok := fields["__CURSOR"]; ok {
+		t.Fatalf("__CURSOR was added: %v", fields)
+	}
+	if fields["MESSAGE"][0] != '{' {
+		t.Fatalf("MESSAGE was overwritten: %v", fields)
+	}
+	if fields["level"] != "info" {
+		t.Fatalf("level wasn't extracted: %v", fields)
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		value  string
+		fields map[string]string
+		err    bool
+	}{
+		{`level=info msg="hello world" took=12ms`, map[string]string{"level": "info", "msg": "hello world", "took": "12ms"}, false},
+		{`a="escaped \"quote\""`, map[string]string{"a": `escaped "quote"`}, false},
+		{`connection closed by peer, retrying with timeout=5s`, nil, false},
+		{`user said "x=1" was fine`, nil, false},
+		{`=value`, nil, false},
+		{`plain prose`, nil, false},
+		{`a=1 b="unterminated`, nil, true},
+	}
+
+	for _, test := range tests {
+		fields, err := parseLogfmt(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if len(fields) != len(test.fields) || (fields == nil) != (test.fields == nil) {
+			t.Errorf("%q: got %v, want %v", test.value, fields, test.fields)
+			continue
+		}
+		for k, v := range test.fields {
+			if fields[k] != v {
+				t.Errorf("%q: got %v, want %v", test.value, fields, test.fields)
+			}
+		}
+	}
+}
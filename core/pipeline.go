@@ -0,0 +1,244 @@
+package core
+
+import (
+	"io"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Stage is a processing step applied to entries after they are read from the
+// source and before they reach the provider.
+type Stage interface {
+	// Process handles e, passing the resulting entries to emit. A stage may
+	// modify, drop, hold back or generate entries.
+	Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry))
+
+	// Flush is called periodically with the current time to let the stage
+	// emit entries it was holding back. A zero time asks for every pending
+	// entry to be emitted, e.g. before stopping.
+	Flush(now time.Time, emit func(*sdjournal.JournalEntry))
+}
+
+// fieldTyper is implemented by stages which know the type of some fields, so
+// marshallers can encode them accordingly.
+type fieldTyper interface {
+	FieldTypes() map[string]FieldType
+}
+
+// holder is implemented by stages which hold entries back, so that the cursor
+// isn't persisted past them until they're emitted.
+type holder interface {
+	// held calls fn with the cursor of every entry held back. Entries standing
+	// for several ones (e.g. multiline groups) must carry the cursor of the
+	// first of them.
+	held(fn func(cursor string))
+}
+
+// PipelineConfig represents the stages to build a Pipeline with. Stages left
+// unconfigured are not part of the pipeline.
+type PipelineConfig struct {
+	Multiline  MultilineConfig
+	Dedup      DedupConfig
+	RateLimit  RateLimitConfig
+	Parse      ParseConfig
+	Kubernetes KubernetesConfig
+	Docker     DockerConfig
+	Host       HostConfig
+	Identity   IdentityConfig
+	Transform  TransformConfig
+	Redact     RedactConfig
+}
+
+// NewPipelineConfig creates a PipelineConfig with every stage disabled but
+// ready to be configured.
+func NewPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Multiline:  NewMultilineConfig(),
+		Dedup:      NewDedupConfig(),
+		RateLimit:  NewRateLimitConfig(),
+		Parse:      NewParseConfig(),
+		Kubernetes: NewKubernetesConfig(),
+		Docker:     NewDockerConfig(),
+		Host:       NewHostConfig(),
+		Identity:   NewIdentityConfig(),
+		Redact:     NewRedactConfig(),
+	}
+}
+
+// Pipeline chains stages, the entries emitted by a stage being processed by
+// the next one.
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline creates a Pipeline made of the supplied stages.
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages}
+}
+
+// NewPipelineFromConfig creates a Pipeline with the stages described by config.
+func NewPipelineFromConfig(config PipelineConfig) (*Pipeline, error) {
+	var stages []Stage
+
+	if !config.Multiline.IsZero() {
+		stage, err := NewMultilineStage(config.Multiline)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Dedup.IsZero() {
+		stage, err := NewDedupStage(config.Dedup)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.RateLimit.IsZero() {
+		stage, err := NewRateLimitStage(config.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Parse.IsZero() {
+		stage, err := NewParseStage(config.Parse)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Kubernetes.IsZero() {
+		stage, err := NewKubernetesStage(config.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Docker.IsZero() {
+		stage, err := NewDockerStage(config.Docker)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Host.IsZero() {
+		stage, err := NewHostStage(config.Host)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Identity.IsZero() {
+		stage, err := NewIdentityStage(config.Identity)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Transform.IsZero() {
+		stage, err := NewTransformStage(config.Transform)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	if !config.Redact.IsZero() {
+		stage, err := NewRedactStage(config.Redact)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	return NewPipeline(stages...), nil
+}
+
+// Len returns the number of stages.
+func (p *Pipeline) Len() int {
+	return len(p.stages)
+}
+
+// Process runs e through every stage.
+func (p *Pipeline) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	p.process(0, e, emit)
+}
+
+// Flush flushes every stage, running what they emit through the rest of them.
+func (p *Pipeline) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+	for i, stage := range p.stages {
+		stage.Flush(now, p.emitter(i+1, emit))
+	}
+}
+
+// holds reports whether any stage may hold entries back.
+func (p *Pipeline) holds() bool {
+	for _, stage := range p.stages {
+		if _, ok := stage.(holder); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// held calls fn with the cursor of every entry held back by the stages.
+func (p *Pipeline) held(fn func(cursor string)) {
+	for _, stage := range p.stages {
+		if h, ok := stage.(holder); ok {
+			h.held(fn)
+		}
+	}
+}
+
+// FieldTypes returns the field types known by every stage.
+func (p *Pipeline) FieldTypes() map[string]FieldType {
+	types := make(map[string]FieldType)
+	for _, stage := range p.stages {
+		if ft, ok := stage.(fieldTyper); ok {
+			for field, t := range ft.FieldTypes() {
+				types[field] = t
+			}
+		}
+	}
+	return types
+}
+
+// Close releases the resources held by the stages.
+func (p *Pipeline) Close() error {
+	var err error
+	for _, stage := range p.stages {
+		if c, ok := stage.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) process(i int, e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	if i == len(p.stages) {
+		emit(e)
+		return
+	}
+	p.stages[i].Process(e, p.emitter(i+1, emit))
+}
+
+func (p *Pipeline) emitter(i int, emit func(*sdjournal.JournalEntry)) func(*sdjournal.JournalEntry) {
+	if i == len(p.stages) {
+		return emit
+	}
+	return func(e *sdjournal.JournalEntry) {
+		p.process(i, e, emit)
+	}
+}
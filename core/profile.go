@@ -0,0 +1,310 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// ProfileECS encodes entries using the Elastic Common Schema.
+	ProfileECS = "ecs"
+	// ProfileOTel encodes entries as OpenTelemetry log records, using its
+	// semantic conventions.
+	ProfileOTel = "otel"
+)
+
+// ParseProfile checks name is a known marshaller profile, empty meaning none.
+func ParseProfile(name string) (string, error) {
+	switch name {
+	case "", ProfileECS, ProfileOTel:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown profile: %s", name)
+}
+
+// fieldProfile describes how journal fields are renamed by a profile.
+type fieldProfile struct {
+	names map[string]string
+	types map[string]FieldType
+	// Prefixes of the fields describing the resource instead of the entry.
+	resource []string
+	// Fields taking the name they're mapped to over any other field mapped
+	// to it.
+	preferred []string
+}
+
+var ecsProfile = fieldProfile{
+	names: map[string]string{
+		"MESSAGE":                "message",
+		"PRIORITY":               "log.syslog.severity.code",
+		"SYSLOG_FACILITY":        "log.syslog.facility.code",
+		"SYSLOG_IDENTIFIER":      "log.syslog.appname",
+		"CODE_FILE":              "log.origin.file.name",
+		"CODE_LINE":              "log.origin.file.line",
+		"CODE_FUNC":              "log.origin.function",
+		"ERRNO":                  "error.code",
+		"_PID":                   "process.pid",
+		"_TID":                   "process.thread.id",
+		"_COMM":                  "process.name",
+		"_EXE":                   "process.executable",
+		"_CMDLINE":               "process.command_line",
+		"_UID":                   "user.id",
+		"_GID":                   "group.id",
+		"_HOSTNAME":              "host.name",
+		"_MACHINE_ID":            "host.id",
+		"_BOOT_ID":               "host.boot.id",
+		"_SYSTEMD_UNIT":          "systemd.unit",
+		"_SYSTEMD_USER_UNIT":     "systemd.user_unit",
+		"_SYSTEMD_SLICE":         "systemd.slice",
+		"_SYSTEMD_INVOCATION_ID": "systemd.invocation_id",
+		"_TRANSPORT":             "systemd.transport",
+		"CONTAINER_ID":           "container.id",
+		"CONTAINER_NAME":         "container.name",
+		"IMAGE_NAME":             "container.image.name",
+		UserNameField:            "user.name",
+		GroupNameField:           "group.name",
+		HostMachineIDField:       "host.id",
+		HostKernelField:          "host.os.kernel",
+		CloudInstanceIDField:     "cloud.instance.id",
+		CloudInstanceTypeField:   "cloud.machine.type",
+		CloudAccountIDField:      "cloud.account.id",
+		DockerImageField:         "container.image.name",
+	},
+	types: map[string]FieldType{
+		"log.syslog.severity.code": FieldInt,
+		"log.syslog.facility.code": FieldInt,
+		"log.origin.file.line":     FieldInt,
+		"process.pid":              FieldInt,
+		"process.thread.id":        FieldInt,
+	},
+}
+
+var otelProfile = fieldProfile{
+	names: map[string]string{
+		"CODE_FILE":              "code.filepath",
+		"CODE_LINE":              "code.lineno",
+		"CODE_FUNC":              "code.function",
+		"_PID":                   "process.pid",
+		"_TID":                   "thread.id",
+		"_COMM":                  "process.executable.name",
+		"_EXE":                   "process.executable.path",
+		"_CMDLINE":               "process.command_line",
+		"_UID":                   "process.user.id",
+		"_GID":                   "process.group.id",
+		"_HOSTNAME":              "host.name",
+		"_MACHINE_ID":            "host.id",
+		"_SYSTEMD_UNIT":          "systemd.unit",
+		"_SYSTEMD_USER_UNIT":     "systemd.user_unit",
+		"_SYSTEMD_SLICE":         "systemd.slice",
+		"_SYSTEMD_INVOCATION_ID": "systemd.invocation_id",
+		"_TRANSPORT":             "systemd.transport",
+		"CONTAINER_ID":           "container.id",
+		"CONTAINER_NAME":         "container.name",
+		"IMAGE_NAME":             "container.image.name",
+		UserNameField:            "process.owner",
+		HostMachineIDField:       "host.id",
+		HostKernelField:          "os.version",
+		CloudInstanceIDField:     "host.id",
+		CloudInstanceTypeField:   "host.type",
+		CloudAccountIDField:      "cloud.account.id",
+		DockerImageField:         "container.image.name",
+		KubernetesNamespaceField: "k8s.namespace.name",
+		KubernetesPodField:       "k8s.pod.name",
+		KubernetesPodUIDField:    "k8s.pod.uid",
+		KubernetesContainerField: "k8s.container.name",
+	},
+	types: map[string]FieldType{
+		"code.lineno":      FieldInt,
+		"process.pid":      FieldInt,
+		"thread.id":        FieldInt,
+		"process.user.id":  FieldInt,
+		"process.group.id": FieldInt,
+	},
+	resource: []string{"host.", "os.", "cloud.", "container.", "k8s.", "service."},
+	// On cloud instances host.id is the instance id, not the machine id.
+	preferred: []string{CloudInstanceIDField},
+}
+
+// syslogLevels are the names of the syslog priorities.
+var syslogLevels = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// otelSeverities maps syslog priorities to OpenTelemetry severity numbers and
+// texts.
+var otelSeverities = []struct {
+	number int
+	text   string
+}{
+	{21, "FATAL"},
+	{19, "ERROR3"},
+	{18, "ERROR2"},
+	{17, "ERROR"},
+	{13, "WARN"},
+	{10, "INFO2"},
+	{9, "INFO"},
+	{5, "DEBUG"},
+}
+
+// newFieldProfile returns the profile named name, with the types of fields
+// coerced by the pipeline carried over to their new names.
+func newFieldProfile(name, namespace string, types map[string]FieldType) *fieldProfile {
+	var p fieldProfile
+	switch name {
+	case ProfileECS:
+		p = ecsProfile
+	case ProfileOTel:
+		p = otelProfile
+	default:
+		return nil
+	}
+
+	profile := &fieldProfile{
+		names:     make(map[string]string, len(p.names)),
+		types:     make(map[string]FieldType, len(p.types)+len(types)),
+		resource:  p.resource,
+		preferred: p.preferred,
+	}
+	for k, v := range p.names {
+		profile.names[k] = v
+	}
+	for k, t := range p.types {
+		profile.types[k] = t
+	}
+	for k, t := range types {
+		profile.types[profile.name(k, namespace)] = t
+	}
+	if namespace != "" {
+		profile.types[namespace+".monotonic_timestamp"] = FieldInt
+	}
+	return profile
+}
+
+// name returns the name of field under the profile. Fields with a dot in
+// their name are already namespaced and kept as they are, the rest go to
+// namespace.
+func (p *fieldProfile) name(field, namespace string) string {
+	if name, ok := p.names[field]; ok {
+		return name
+	}
+	if strings.Contains(field, ".") || namespace == "" {
+		return field
+	}
+	return namespace + "." + field
+}
+
+// mapFields renames the fields of e. When several fields get the same name
+// the preferred one wins, otherwise the first one in field name order.
+func (p *fieldProfile) mapFields(e *sdjournal.JournalEntry, namespace string) map[string]string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make(map[string]string, len(e.Fields)+2)
+	for _, key := range p.preferred {
+		if value, ok := e.Fields[key]; ok {
+			fields[p.name(key, namespace)] = value
+		}
+	}
+	for _, key := range keys {
+		name := p.name(key, namespace)
+		if _, ok := fields[name]; !ok {
+			fields[name] = e.Fields[key]
+		}
+	}
+	return fields
+}
+
+func (p *fieldProfile) isResource(name string) bool {
+	for _, prefix := range p.resource {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalECS writes e as an Elastic Common Schema document.
+func (m *JournalEntryMarshaller) marshalECS(e *sdjournal.JournalEntry) {
+	namespace := m.config.Namespace
+	fields := m.profile.mapFields(e, namespace)
+	if level, ok := syslogLevel(e.Fields["PRIORITY"]); ok {
+		fields["log.level"] = syslogLevels[level]
+	}
+	if namespace != "" {
+		fields[namespace+".cursor"] = e.Cursor
+		fields[namespace+".monotonic_timestamp"] = strconv.FormatUint(e.MonotonicTimestamp, 10)
+	}
+
+	m.buf.WriteString(`{"@timestamp":`)
+	m.buf.WriteJsonString(FormatUsec(e.RealtimeTimestamp))
+	m.buf.WriteByte(',')
+	m.writeNestedFields(fields)
+	m.buf.Rewind(1)
+	m.buf.WriteByte('}')
+}
+
+// marshalOTel writes e as an OpenTelemetry log record, with the fields
+// describing where it comes from as resource attributes.
+func (m *JournalEntryMarshaller) marshalOTel(e *sdjournal.JournalEntry) {
+	namespace := m.config.Namespace
+	fields := m.profile.mapFields(e, namespace)
+	body, hasBody := e.Fields["MESSAGE"]
+	delete(fields, m.profile.name("MESSAGE", namespace))
+	if namespace != "" {
+		fields[namespace+".cursor"] = e.Cursor
+	}
+
+	m.buf.WriteString(`{"timestamp":`)
+	m.buf.WriteUint(e.RealtimeTimestamp * 1000)
+	if level, ok := syslogLevel(e.Fields["PRIORITY"]); ok {
+		delete(fields, m.profile.name("PRIORITY", namespace))
+		m.buf.WriteString(`,"severity_number":`)
+		m.buf.WriteUint(uint64(otelSeverities[level].number))
+		m.buf.WriteString(`,"severity_text":`)
+		m.buf.WriteJsonString(otelSeverities[level].text)
+	}
+	if hasBody {
+		m.buf.WriteString(`,"body":`)
+		m.writeValue("", body)
+	}
+
+	var resource, attributes []string
+	for name := range fields {
+		if m.profile.isResource(name) {
+			resource = append(resource, name)
+		} else {
+			attributes = append(attributes, name)
+		}
+	}
+	m.writeOTelAttributes("resource", resource, fields)
+	m.writeOTelAttributes("attributes", attributes, fields)
+	m.buf.WriteByte('}')
+}
+
+func (m *JournalEntryMarshaller) writeOTelAttributes(key string, names []string, fields map[string]string) {
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	m.buf.WriteString(`,"` + key + `":{`)
+	for _, name := range names {
+		m.writeField(name, fields[name])
+	}
+	m.buf.Rewind(1)
+	m.buf.WriteByte('}')
+}
+
+// syslogLevel parses a syslog priority.
+func syslogLevel(priority string) (int, bool) {
+	level, err := strconv.Atoi(priority)
+	if err != nil || level < 0 || level >= len(syslogLevels) {
+		return 0, false
+	}
+	return level, true
+}
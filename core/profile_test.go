@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+func newTestProfileEntry(fields map[string]string) *sdjournal.JournalEntry {
+	e := newTestMarshallerEntry(map[string]string{
+		"MESSAGE":       "hello",
+		"PRIORITY":      "3",
+		"_PID":          "42",
+		"_SYSTEMD_UNIT": "ssh.service",
+		"_HOSTNAME":     "node-1",
+		"CUSTOM":        "x",
+	})
+	for k, v := range fields {
+		e.Fields[k] = v
+	}
+	return e
+}
+
+func marshalProfile(profile string, namespace string, types map[string]FieldType, e *sdjournal.JournalEntry) string {
+	config := NewMarshallerConfig()
+	config.Profile = profile
+	config.Namespace = namespace
+	config.FieldTypes = types
+	return marshal(config, e)
+}
+
+func TestECSProfile(t *testing.T) {
+	tests := []struct {
+		namespace string
+		types     map[string]FieldType
+		fields    map[string]string
+		want      string
+	}{
+		{
+			"journald", nil, nil,
+			`{"@timestamp":"2017-01-05T03:04:05.000007Z","host":{"name":"node-1"},` +
+				`"journald":{"CUSTOM":"x","cursor":"s=1;i=2","monotonic_timestamp":1500000},` +
+				`"log":{"level":"err","syslog":{"severity":{"code":3}}},"message":"hello",` +
+				`"process":{"pid":42},"systemd":{"unit":"ssh.service"}}`,
+		},
+		{
+			"", nil, nil,
+			`{"@timestamp":"2017-01-05T03:04:05.000007Z","CUSTOM":"x","host":{"name":"node-1"},` +
+				`"log":{"level":"err","syslog":{"severity":{"code":3}}},"message":"hello",` +
+				`"process":{"pid":42},"systemd":{"unit":"ssh.service"}}`,
+		},
+		{
+			// Coerced fields keep their type under their new name, enrichment
+			// fields are mapped too.
+			"journald",
+			map[string]FieldType{"CUSTOM": FieldInt, "_UID": FieldInt},
+			map[string]string{"CUSTOM": "7", "_UID": "1000", UserNameField: "alice", HostMachineIDField: "m1",
+				CloudInstanceIDField: "i-0123", "PRIORITY": "invalid"},
+			`{"@timestamp":"2017-01-05T03:04:05.000007Z","cloud":{"instance":{"id":"i-0123"}},` +
+				`"host":{"id":"m1","name":"node-1"},` +
+				`"journald":{"CUSTOM":7,"cursor":"s=1;i=2","monotonic_timestamp":1500000},` +
+				`"log":{"syslog":{"severity":{"code":"invalid"}}},"message":"hello",` +
+				`"process":{"pid":42},"systemd":{"unit":"ssh.service"},"user":{"id":1000,"name":"alice"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := marshalProfile(ProfileECS, test.namespace, test.types, newTestProfileEntry(test.fields)); got != test.want {
+			t.Errorf("got  %s\nwant %s", got, test.want)
+		}
+	}
+}
+
+func TestOTelProfile(t *testing.T) {
+	e := newTestProfileEntry(map[string]string{KubernetesPodField: "web-1"})
+	want := `{"timestamp":1483585445000007000,"severity_number":17,"severity_text":"ERROR","body":"hello",` +
+		`"resource":{"host.name":"node-1","k8s.pod.name":"web-1"},` +
+		`"attributes":{"journald.CUSTOM":"x","journald.cursor":"s=1;i=2","process.pid":42,"systemd.unit":"ssh.service"}}`
+	if got := marshalProfile(ProfileOTel, "journald", nil, e); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// Neither body nor severity without MESSAGE and PRIORITY.
+	e = newTestMarshallerEntry(map[string]string{"_PID": "42"})
+	want = `{"timestamp":1483585445000007000,"attributes":{"process.pid":42}}`
+	if got := marshalProfile(ProfileOTel, "", nil, e); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestOTelProfileSeverities(t *testing.T) {
+	tests := []struct {
+		priority string
+		number   int
+		text     string
+	}{
+		{"0", 21, "FATAL"},
+		{"3", 17, "ERROR"},
+		{"4", 13, "WARN"},
+		{"6", 9, "INFO"},
+		{"7", 5, "DEBUG"},
+		{"8", 0, ""},
+	}
+
+	for _, test := range tests {
+		e := newTestMarshallerEntry(map[string]string{"PRIORITY": test.priority})
+		var record struct {
+			Number int    `json:"severity_number"`
+			Text   string `json:"severity_text"`
+		}
+		if err := json.Unmarshal([]byte(marshalProfile(ProfileOTel, "journald", nil, e)), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Number != test.number || record.Text != test.text {
+			t.Errorf("priority %s: got %d %q, want %d %q", test.priority, record.Number, record.Text, test.number, test.text)
+		}
+	}
+}
+
+// TestOTelProfileHostID checks the instance id is the host id on cloud
+// instances, whichever other fields map to host.id.
+func TestOTelProfileHostID(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{"_MACHINE_ID": "m1"}, "m1"},
+		{map[string]string{HostMachineIDField: "m1"}, "m1"},
+		{map[string]string{"_MACHINE_ID": "m1", HostMachineIDField: "m1", CloudInstanceIDField: "i-0123"}, "i-0123"},
+		{map[string]string{HostMachineIDField: "m1", CloudInstanceIDField: "i-0123"}, "i-0123"},
+	}
+
+	for _, test := range tests {
+		var record struct {
+			Resource map[string]interface{} `json:"resource"`
+		}
+		data := marshalProfile(ProfileOTel, "journald", nil, newTestMarshallerEntry(test.fields))
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			t.Fatal(err)
+		}
+		if got := record.Resource["host.id"]; got != test.want {
+			t.Errorf("%v: got host.id %v, want %s", test.fields, got, test.want)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	for _, name := range []string{"", ProfileECS, ProfileOTel} {
+		if _, err := ParseProfile(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	if _, err := ParseProfile("gelf"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
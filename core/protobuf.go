@@ -0,0 +1,145 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Tags of the fields of the messages in proto/journal.proto.
+const (
+	protobufCursorTag    = 1<<3 | 2
+	protobufRealtimeTag  = 2<<3 | 0
+	protobufMonotonicTag = 3<<3 | 0
+	protobufFieldsTag    = 4<<3 | 2
+	protobufKeyTag       = 1<<3 | 2
+	protobufValueTag     = 2<<3 | 2
+	protobufEntriesTag   = 1<<3 | 2
+)
+
+// ProtobufEncoder encodes entries as JournalEntry messages and batches of them
+// as JournalEntryBatch messages, as described by proto/journal.proto. Field
+// values are always encoded as bytes.
+type ProtobufEncoder struct {
+	sortKeys bool
+	keys     []string
+	buf      []byte
+}
+
+// NewProtobufEncoder creates a ProtobufEncoder configured with config. Only
+// SortKeys applies, the schema is fixed.
+func NewProtobufEncoder(config MarshallerConfig) *ProtobufEncoder {
+	return &ProtobufEncoder{sortKeys: config.SortKeys}
+}
+
+func (m *ProtobufEncoder) MarshalOne(e *sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.writeEntry(e)
+	return m.buf
+}
+
+func (m *ProtobufEncoder) MarshalAll(ea []*sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	for _, e := range ea {
+		m.buf = append(m.buf, protobufEntriesTag)
+		m.writeVarint(uint64(m.entrySize(e)))
+		m.writeEntry(e)
+	}
+	return m.buf
+}
+
+func (m *ProtobufEncoder) batchStart(n int) []byte {
+	return nil
+}
+
+func (m *ProtobufEncoder) batchEntry(i int, e *sdjournal.JournalEntry) []byte {
+	m.buf = m.buf[:0]
+	m.buf = append(m.buf, protobufEntriesTag)
+	m.writeVarint(uint64(m.entrySize(e)))
+	m.writeEntry(e)
+	return m.buf
+}
+
+func (m *ProtobufEncoder) batchEnd() []byte {
+	return nil
+}
+
+func (m *ProtobufEncoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (m *ProtobufEncoder) writeEntry(e *sdjournal.JournalEntry) {
+	if e.Cursor != "" {
+		m.buf = append(m.buf, protobufCursorTag)
+		m.writeVarint(uint64(len(e.Cursor)))
+		m.buf = append(m.buf, e.Cursor...)
+	}
+	if e.RealtimeTimestamp != 0 {
+		m.buf = append(m.buf, protobufRealtimeTag)
+		m.writeVarint(e.RealtimeTimestamp)
+	}
+	if e.MonotonicTimestamp != 0 {
+		m.buf = append(m.buf, protobufMonotonicTag)
+		m.writeVarint(e.MonotonicTimestamp)
+	}
+
+	m.keys = m.keys[:0]
+	for key := range e.Fields {
+		m.keys = append(m.keys, key)
+	}
+	if m.sortKeys {
+		sort.Strings(m.keys)
+	}
+	for _, key := range m.keys {
+		value := e.Fields[key]
+		m.buf = append(m.buf, protobufFieldsTag)
+		m.writeVarint(uint64(mapEntrySize(key, value)))
+		m.buf = append(m.buf, protobufKeyTag)
+		m.writeVarint(uint64(len(key)))
+		m.buf = append(m.buf, key...)
+		m.buf = append(m.buf, protobufValueTag)
+		m.writeVarint(uint64(len(value)))
+		m.buf = append(m.buf, value...)
+	}
+}
+
+// entrySize returns the encoded size of e, without its tag and length.
+func (m *ProtobufEncoder) entrySize(e *sdjournal.JournalEntry) int {
+	n := 0
+	if e.Cursor != "" {
+		n += 1 + varintSize(uint64(len(e.Cursor))) + len(e.Cursor)
+	}
+	if e.RealtimeTimestamp != 0 {
+		n += 1 + varintSize(e.RealtimeTimestamp)
+	}
+	if e.MonotonicTimestamp != 0 {
+		n += 1 + varintSize(e.MonotonicTimestamp)
+	}
+	for key, value := range e.Fields {
+		size := mapEntrySize(key, value)
+		n += 1 + varintSize(uint64(size)) + size
+	}
+	return n
+}
+
+func (m *ProtobufEncoder) writeVarint(v uint64) {
+	for v >= 0x80 {
+		m.buf = append(m.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	m.buf = append(m.buf, byte(v))
+}
+
+func mapEntrySize(key, value string) int {
+	return 1 + varintSize(uint64(len(key))) + len(key) +
+		1 + varintSize(uint64(len(value))) + len(value)
+}
+
+func varintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
@@ -5,6 +5,9 @@ type ProviderConfig interface {
 	BulkSize() int
 }
 
+// Provider publishes batches of entries, returning how many of them were
+// published. Publish is called concurrently when more than one batch is
+// allowed in flight.
 type Provider interface {
 	Publish(JournalEntryIterator) (int, error)
 }
\ No newline at end of file
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	SampleRandom = "random"
+	SampleHash   = "hash"
+
+	// SuppressedCountField holds the number of suppressed entries in the
+	// summaries emitted by a RateLimitStage.
+	SuppressedCountField = "SUPPRESSED_COUNT"
+)
+
+// RateLimitConfig represents options to drive the behavior of a RateLimitStage.
+type RateLimitConfig struct {
+	// Fields identifying the streams limits are applied to.
+	Keys []string
+
+	// Entries per second allowed for each stream, 0 means no limit.
+	Rate float64
+
+	// Entries allowed in a burst over Rate.
+	Burst int
+
+	// Ratio of entries to keep by priority, as PRIORITY=RATIO (e.g. 7=0.1 to
+	// keep 10% of debug entries). Priorities not listed are all kept.
+	Sample []string
+
+	// How entries are sampled: randomly or deterministically by hashing
+	// their cursor.
+	SampleMode string
+
+	// How often summaries of suppressed entries are emitted.
+	SummaryInterval time.Duration
+}
+
+// NewRateLimitConfig creates a RateLimitConfig limiting per unit.
+func NewRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Keys:            []string{"_SYSTEMD_UNIT"},
+		Burst:           100,
+		SampleMode:      SampleHash,
+		SummaryInterval: 1 * time.Minute,
+	}
+}
+
+// IsZero reports whether neither limits nor sampling are configured.
+func (c RateLimitConfig) IsZero() bool {
+	return c.Rate <= 0 && len(c.Sample) == 0
+}
+
+// RateLimitStage samples entries by priority and applies token bucket rate
+// limits per stream, so a single crash looping unit can't flood the pipeline.
+// Summaries of how many entries were suppressed are emitted periodically.
+type RateLimitStage struct {
+	keys     []string
+	rate     float64
+	burst    float64
+	sample   map[string]float64
+	hash     bool
+	interval time.Duration
+
+	buckets     map[string]*tokenBucket
+	suppressed  map[string]*suppressedStream
+	lastSummary time.Time
+	lastCursor  string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type suppressedStream struct {
+	fields map[string]string
+	count  uint64
+}
+
+// NewRateLimitStage creates a RateLimitStage as described by config.
+func NewRateLimitStage(config RateLimitConfig) (*RateLimitStage, error) {
+	s := &RateLimitStage{
+		keys:        config.Keys,
+		rate:        config.Rate,
+		burst:       float64(config.Burst),
+		sample:      make(map[string]float64),
+		interval:    config.SummaryInterval,
+		buckets:     make(map[string]*tokenBucket),
+		suppressed:  make(map[string]*suppressedStream),
+		lastSummary: time.Now(),
+	}
+
+	switch config.SampleMode {
+	case SampleRandom:
+	case SampleHash:
+		s.hash = true
+	default:
+		return nil, fmt.Errorf("unknown sample mode: %s", config.SampleMode)
+	}
+
+	if s.burst < 1 {
+		s.burst = 1
+	}
+
+	pairs, err := splitPairs(config.Sample)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pairs {
+		ratio, err := strconv.ParseFloat(p[1], 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid sample ratio for priority %s: %s", p[0], p[1])
+		}
+		s.sample[p[0]] = ratio
+	}
+
+	return s, nil
+}
+
+func (s *RateLimitStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	s.lastCursor = e.Cursor
+
+	if !s.sampled(e) || !s.allowed(e, time.Now()) {
+		s.suppress(e)
+		return
+	}
+
+	emit(e)
+}
+
+func (s *RateLimitStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+	if !now.IsZero() && now.Sub(s.lastSummary) < s.interval {
+		return
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	keys := make([]string, 0, len(s.suppressed))
+	for key := range s.suppressed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		emit(s.summary(s.suppressed[key], now))
+	}
+
+	s.suppressed = make(map[string]*suppressedStream)
+	s.lastSummary = now
+
+	// Forget about streams whose bucket is full again.
+	for key, b := range s.buckets {
+		if s.rate <= 0 || now.Sub(b.last).Seconds()*s.rate+b.tokens >= s.burst {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// sampled reports whether e is kept by priority based sampling.
+func (s *RateLimitStage) sampled(e *sdjournal.JournalEntry) bool {
+	ratio, ok := s.sample[e.Fields["PRIORITY"]]
+	if !ok || ratio >= 1 {
+		return true
+	}
+
+	if s.hash {
+		h := fnv.New32a()
+		h.Write([]byte(e.Cursor))
+		return float64(h.Sum32()%10000) < ratio*10000
+	}
+	return rand.Float64() < ratio
+}
+
+// allowed reports whether the bucket of the stream e belongs to has a token.
+func (s *RateLimitStage) allowed(e *sdjournal.JournalEntry, now time.Time) bool {
+	if s.rate <= 0 {
+		return true
+	}
+
+	key := streamKey(e, s.keys)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: s.burst, last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * s.rate
+	if b.tokens > s.burst {
+		b.tokens = s.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (s *RateLimitStage) suppress(e *sdjournal.JournalEntry) {
+	key := streamKey(e, s.keys)
+	stream, ok := s.suppressed[key]
+	if !ok {
+		stream = &suppressedStream{fields: make(map[string]string)}
+		for _, k := range s.keys {
+			if v, ok := e.Fields[k]; ok {
+				stream.fields[k] = v
+			}
+		}
+		s.suppressed[key] = stream
+	}
+	stream.count++
+}
+
+// summary creates a synthetic entry reporting how many entries of a stream
+// were suppressed. It carries the cursor of the last entry seen, as every
+// entry up to it was handled.
+func (s *RateLimitStage) summary(stream *suppressedStream, now time.Time) *sdjournal.JournalEntry {
+	fields := make(map[string]string, len(stream.fields)+4)
+	names := make([]string, 0, len(s.keys))
+	for k, v := range stream.fields {
+		fields[k] = v
+	}
+	for _, k := range s.keys {
+		names = append(names, k+"="+stream.fields[k])
+	}
+
+	fields["MESSAGE"] = fmt.Sprintf("Suppressed %d entries of %s in the last %v",
+		stream.count, strings.Join(names, " "), now.Sub(s.lastSummary)/time.Second*time.Second)
+	fields["PRIORITY"] = "4"
+	fields["SYSLOG_IDENTIFIER"] = cliName
+	fields[SuppressedCountField] = strconv.FormatUint(stream.count, 10)
+
+	usec := uint64(now.UnixNano() / int64(time.Microsecond))
+	return &sdjournal.JournalEntry{
+		Fields:            fields,
+		Cursor:            s.lastCursor,
+		RealtimeTimestamp: usec,
+	}
+}
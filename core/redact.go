@@ -0,0 +1,276 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+// RedactConfig represents options to drive the behavior of a RedactStage.
+type RedactConfig struct {
+	// Built-in detectors to apply: creditcard, email, ipv4, ipv6, bearer, aws
+	// and/or jwt.
+	Detectors []string
+
+	// Additional regular expressions to redact.
+	Regexes []string
+
+	// Fields to look into, glob patterns are allowed.
+	Fields []string
+
+	// What to do with matches: mask them, replace them with their keyed HMAC
+	// or drop the whole entry.
+	Action string
+
+	// Replacement used by the mask action.
+	Mask string
+
+	// Key used by the hash action, given as is or read from a file.
+	HashKey     string
+	HashKeyFile string
+}
+
+// NewRedactConfig creates a RedactConfig masking matches found in MESSAGE.
+func NewRedactConfig() RedactConfig {
+	return RedactConfig{
+		Fields: []string{"MESSAGE"},
+		Action: RedactMask,
+		Mask:   "[REDACTED]",
+	}
+}
+
+// IsZero reports whether nothing is configured to be redacted.
+func (c RedactConfig) IsZero() bool {
+	return len(c.Detectors) == 0 && len(c.Regexes) == 0
+}
+
+type redactDetector struct {
+	name     string
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+var redactDetectors = map[string]redactDetector{
+	"creditcard": {"creditcard", regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), validLuhn},
+	"email":      {"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), nil},
+	"ipv4":       {"ipv4", regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`), nil},
+	"ipv6":       {"ipv6", regexp.MustCompile(`[0-9A-Za-z_.:]*:[0-9A-Za-z_.:]*:[0-9A-Za-z_.:]*[0-9A-Za-z_:]`), validIPv6},
+	"bearer":     {"bearer", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`), nil},
+	"aws":        {"aws", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i)aws_secret_access_key["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`), nil},
+	"jwt":        {"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), nil},
+}
+
+// RedactStage removes sensitive data (credit card numbers, emails, IP
+// addresses, tokens and keys, or custom patterns) from entries before any
+// provider sees them.
+type RedactStage struct {
+	detectors []redactDetector
+	fields    []string
+	action    string
+	mask      string
+	key       *Secret
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// NewRedactStage creates a RedactStage as described by config.
+func NewRedactStage(config RedactConfig) (*RedactStage, error) {
+	s := &RedactStage{
+		fields: config.Fields,
+		action: config.Action,
+		mask:   config.Mask,
+		counts: make(map[string]uint64),
+	}
+
+	key, err := NewSecret(config.HashKey, config.HashKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s.key = key
+
+	switch s.action {
+	case RedactMask, RedactDrop:
+	case RedactHash:
+		if s.key.IsZero() {
+			return nil, errors.New("hash redaction requires a key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown redact action: %s", s.action)
+	}
+
+	for _, name := range config.Detectors {
+		d, ok := redactDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact detector: %s", name)
+		}
+		s.detectors = append(s.detectors, d)
+	}
+
+	for i, expr := range config.Regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		s.detectors = append(s.detectors, redactDetector{name: fmt.Sprintf("regex%d", i), re: re})
+	}
+
+	return s, nil
+}
+
+func (s *RedactStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	for key, value := range e.Fields {
+		if !matchAny(s.fields, key) {
+			continue
+		}
+
+		redacted, n := s.redact(value)
+		if n == 0 {
+			continue
+		}
+		if s.action == RedactDrop {
+			return
+		}
+		e.Fields[key] = redacted
+	}
+
+	emit(e)
+}
+
+func (s *RedactStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// Close logs how many redactions were applied.
+func (s *RedactStage) Close() error {
+	total := s.Count()
+	if total == 0 {
+		return nil
+	}
+
+	counts := s.Counts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	log.Printf("Redacted %d matches: %s", total, strings.Join(names, " "))
+	return nil
+}
+
+// Count returns the number of redactions applied so far.
+func (s *RedactStage) Count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := uint64(0)
+	for _, n := range s.counts {
+		total += n
+	}
+	return total
+}
+
+// Counts returns the number of redactions applied so far by detector.
+func (s *RedactStage) Counts() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]uint64, len(s.counts))
+	for name, n := range s.counts {
+		counts[name] = n
+	}
+	return counts
+}
+
+// redact applies every detector to value, returning the redacted value and
+// the number of matches.
+func (s *RedactStage) redact(value string) (string, int) {
+	total := 0
+	for _, d := range s.detectors {
+		n := 0
+		value = d.re.ReplaceAllStringFunc(value, func(match string) string {
+			if d.validate != nil && !d.validate(match) {
+				return match
+			}
+			n++
+			return s.replacement(match)
+		})
+		if n > 0 {
+			s.mu.Lock()
+			s.counts[d.name] += uint64(n)
+			s.mu.Unlock()
+			total += n
+		}
+	}
+	return value, total
+}
+
+func (s *RedactStage) replacement(match string) string {
+	if s.action != RedactHash {
+		return s.mask
+	}
+	mac := hmac.New(sha256.New, []byte(s.key.Value()))
+	mac.Write([]byte(match))
+	return "[HMAC:" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]) + "]"
+}
+
+// validLuhn reports whether the digits in s pass the Luhn checksum.
+func validLuhn(s string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// minIPv6Groups is how many groups IPv6 addresses must have at least, as
+// shorter ones (e.g. dead::beef) can't be told from code.
+const minIPv6Groups = 3
+
+// validIPv6 reports whether s is an IPv6 address. s is a whole word, colons
+// and dots included, so that things like timestamps, MAC addresses or C++
+// and Rust paths (std::vector, cafe::deadbeef) aren't partly matched.
+func validIPv6(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return false
+	}
+
+	groups := 0
+	for _, group := range strings.Split(s, ":") {
+		if group != "" {
+			groups++
+		}
+	}
+	return groups >= minIPv6Groups
+}
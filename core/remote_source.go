@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// SourceRemote receives entries uploaded by systemd-journal-upload.
+	SourceRemote = "remote"
+
+	// Fields added to every received entry identifying who sent it.
+	RemoteSenderAddrField = "SENDER_ADDR"
+	RemoteSenderNameField = "SENDER_NAME"
+
+	remoteContentType = "application/vnd.fdo.journal"
+)
+
+// RemoteSourceConfig represents options to drive the behavior of a RemoteSource.
+type RemoteSourceConfig struct {
+	// Address to listen for uploads on.
+	ListenAddr string
+
+	// Certificate and key to serve HTTPS, plain HTTP is used if empty.
+	CertFile string
+	KeyFile  string
+
+	// If not empty clients are required to present a certificate signed by
+	// one of the CAs in this file. Its common name identifies the sender.
+	TrustedCAFile string
+
+	// Maximum time to read the headers of a request, and the whole of it,
+	// 0 meaning no limit. Uploads taking longer are cut and the client has to
+	// retry them.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+
+	// Time keep-alive connections wait for the next upload.
+	IdleTimeout time.Duration
+}
+
+// NewRemoteSourceConfig creates a RemoteSourceConfig listening on the same
+// port as systemd-journal-remote, with timeouts so that idle or stalled
+// clients don't hold connections forever.
+func NewRemoteSourceConfig() RemoteSourceConfig {
+	return RemoteSourceConfig{
+		ListenAddr:        ":19532",
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// RemoteSource is a Source which acts as a systemd-journal-remote receiver:
+// it accepts uploads in Journal Export Format from systemd-journal-upload
+// clients and feeds them into the forwarder. It allows running a small
+// aggregator tier so nodes don't need outbound access or sink credentials.
+//
+// Uploads are acknowledged once every entry was handed to the forwarder, not
+// once they're published: the position of each client is tracked by the client
+// itself, so entries accepted but not yet published when the forwarder dies
+// are lost. Delivery is at-most-once. Waiting for entries to be published
+// instead isn't possible, processing stages may drop or hold back any of them.
+type RemoteSource struct {
+	listener net.Listener
+	server   *http.Server
+	since    uint64
+	entryc   chan *sdjournal.JournalEntry
+	closec   chan bool
+}
+
+// NewRemoteSource starts listening for uploads as described by config.
+// Entries are not accepted until Follow is called.
+func NewRemoteSource(config RemoteSourceConfig) (*RemoteSource, error) {
+	s := &RemoteSource{
+		entryc: make(chan *sdjournal.JournalEntry),
+		closec: make(chan bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", s.handleUpload)
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.CertFile != "" {
+		tlsConfig, err := remoteTLSConfig(config)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.listener = listener
+
+	return s, nil
+}
+
+func remoteTLSConfig(config RemoteSourceConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.TrustedCAFile != "" {
+		pool, err := loadCertPool(config.TrustedCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// SeekCursor is a no-op, every client keeps track of what it already uploaded
+// and was acknowledged.
+func (s *RemoteSource) SeekCursor(cursor string) error {
+	return nil
+}
+
+// SeekRealtime discards every received entry older than t.
+func (s *RemoteSource) SeekRealtime(t time.Time) error {
+	s.since = uint64(t.UnixNano() / int64(time.Microsecond))
+	return nil
+}
+
+func (s *RemoteSource) Close() error {
+	return s.listener.Close()
+}
+
+func (s *RemoteSource) Follow(ctx context.Context,
+	recvc chan<- *sdjournal.JournalEntry,
+	errc chan<- error) {
+	servec := make(chan error, 1)
+	go func() {
+		servec <- s.server.Serve(s.listener)
+	}()
+
+	for {
+		select {
+		case e := <-s.entryc:
+			select {
+			case recvc <- e:
+			case <-ctx.Done():
+				s.shutdown()
+				return
+			}
+		case err := <-servec:
+			close(s.closec)
+			select {
+			case errc <- err:
+			case <-ctx.Done():
+			}
+			return
+		case <-ctx.Done():
+			s.shutdown()
+			return
+		}
+	}
+}
+
+func (s *RemoteSource) shutdown() {
+	close(s.closec)
+	s.server.Close()
+}
+
+func (s *RemoteSource) handleUpload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != remoteContentType {
+		http.Error(w, "Content-Type: "+remoteContentType+" is required.", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	addr, name := remoteSender(req)
+	reader := NewExportReader(req.Body)
+	for {
+		e, err := reader.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid upload from %s: %v.", addr, err), http.StatusBadRequest)
+			return
+		}
+		if e.RealtimeTimestamp < s.since {
+			continue
+		}
+
+		e.Fields[RemoteSenderAddrField] = addr
+		if name != "" {
+			e.Fields[RemoteSenderNameField] = name
+		}
+
+		select {
+		case s.entryc <- e:
+		case <-s.closec:
+			http.Error(w, errRemoteSourceClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("OK.\n"))
+}
+
+var errRemoteSourceClosed = errors.New("receiver is shutting down")
+
+// remoteSender identifies the client of an upload by its address and, if it
+// authenticated with a certificate, by its common name.
+func remoteSender(req *http.Request) (string, string) {
+	addr := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	name := ""
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		name = req.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+
+	return addr, name
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// startRemoteSource follows a RemoteSource listening on a random port until
+// the returned function is called.
+func startRemoteSource(t *testing.T, config RemoteSourceConfig) (string, <-chan *sdjournal.JournalEntry, func()) {
+	config.ListenAddr = "127.0.0.1:0"
+	s, err := NewRemoteSource(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recvc := make(chan *sdjournal.JournalEntry, 100)
+	donec := make(chan bool)
+	go func() {
+		defer close(donec)
+		s.Follow(ctx, recvc, make(chan error, 1))
+	}()
+
+	return "http://" + s.listener.Addr().String() + "/upload", recvc, func() {
+		cancel()
+		<-donec
+		s.Close()
+	}
+}
+
+func upload(t *testing.T, url, contentType, body string) (int, string) {
+	res, err := http.Post(url, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(data)
+}
+
+func TestRemoteSourceReceivesUploads(t *testing.T) {
+	url, recvc, stop := startRemoteSource(t, NewRemoteSourceConfig())
+	defer stop()
+
+	stream := testExportStream("a", "b") + exportBinaryField("MESSAGE", "c\nd") + "\n"
+	if status, body := upload(t, url, remoteContentType, stream); status != http.StatusAccepted {
+		t.Fatalf("got %d: %s", status, body)
+	}
+
+	for _, want := range []string{"a", "b", "c\nd"} {
+		select {
+		case e := <-recvc:
+			if e.Fields["MESSAGE"] != want {
+				t.Errorf("got %q, want %q", e.Fields["MESSAGE"], want)
+			}
+			if addr := e.Fields[RemoteSenderAddrField]; addr != "127.0.0.1" {
+				t.Errorf("got sender %q", addr)
+			}
+			if _, ok := e.Fields[RemoteSenderNameField]; ok {
+				t.Error("sender named without a client certificate")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %q not received", want)
+		}
+	}
+}
+
+func TestRemoteSourceRejectsInvalidUploads(t *testing.T) {
+	url, _, stop := startRemoteSource(t, NewRemoteSourceConfig())
+	defer stop()
+
+	if status, _ := upload(t, url, "text/plain", testExportStream("a")); status != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %d", status)
+	}
+	if status, _ := upload(t, url, remoteContentType, "MESSAGE\n\x05"); status != http.StatusBadRequest {
+		t.Errorf("truncated upload: got %d", status)
+	}
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("get: got %d", res.StatusCode)
+	}
+}
+
+func TestRemoteSourceTimesOutStalledClients(t *testing.T) {
+	config := NewRemoteSourceConfig()
+	config.ReadHeaderTimeout = 50 * time.Millisecond
+	url, _, stop := startRemoteSource(t, config)
+	defer stop()
+
+	conn, err := net.Dial("tcp", strings.TrimSuffix(strings.TrimPrefix(url, "http://"), "/upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Never finish the headers, the server must hang up on its own.
+	if _, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: test\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		t.Fatal("connection kept open past the header timeout")
+	}
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Output formats of renderers.
+const (
+	OutputJSON         = "json"
+	OutputShort        = "short"
+	OutputShortISO     = "short-iso"
+	OutputShortPrecise = "short-precise"
+	OutputVerbose      = "verbose"
+	OutputCat          = "cat"
+	OutputWithUnit     = "with-unit"
+	OutputTemplate     = "template"
+)
+
+// Renderer renders an entry as a line, newline included, for line oriented
+// sinks.
+type Renderer interface {
+	Render(e *sdjournal.JournalEntry) []byte
+}
+
+// RendererConfig represents options to drive the behavior of a Renderer.
+type RendererConfig struct {
+	// Output format: json, one of the journalctl ones (short, short-iso,
+	// short-precise, verbose, cat, with-unit) or template.
+	Format string
+
+	// text/template the template format renders. It's executed with a
+	// TemplateEntry.
+	Template string
+
+	// Render timestamps in UTC instead of local time.
+	UTC bool
+}
+
+// NewRendererConfig creates a RendererConfig rendering JSON.
+func NewRendererConfig() RendererConfig {
+	return RendererConfig{
+		Format: OutputJSON,
+	}
+}
+
+// DefaultRendererConfig is used by renderers created with NewRenderer. It's
+// set up by Main from the command line.
+var DefaultRendererConfig = NewRendererConfig()
+
+// NewRenderer creates a Renderer configured with DefaultRendererConfig.
+func NewRenderer() (Renderer, error) {
+	return NewRendererWithConfig(DefaultRendererConfig)
+}
+
+// NewRendererWithConfig creates a Renderer configured with config. JSON is
+// rendered by a JournalEntryMarshaller configured with
+// DefaultMarshallerConfig.
+func NewRendererWithConfig(config RendererConfig) (Renderer, error) {
+	switch config.Format {
+	case OutputJSON:
+		return &jsonRenderer{marshaller: NewJournalEntryMarshaller()}, nil
+	case OutputShort, OutputShortISO, OutputShortPrecise, OutputVerbose, OutputCat, OutputWithUnit:
+		return &TextRenderer{format: config.Format, utc: config.UTC}, nil
+	case OutputTemplate:
+		tmpl, err := template.New("output").Option("missingkey=zero").Parse(config.Template)
+		if err != nil {
+			return nil, err
+		}
+		return &TemplateRenderer{template: tmpl, utc: config.UTC}, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s", config.Format)
+}
+
+type jsonRenderer struct {
+	marshaller *JournalEntryMarshaller
+}
+
+func (r *jsonRenderer) Render(e *sdjournal.JournalEntry) []byte {
+	line := r.marshaller.MarshalOne(e)
+	return append(line, '\n')
+}
+
+// TextRenderer renders entries like journalctl does.
+type TextRenderer struct {
+	format string
+	utc    bool
+	buf    bytes.Buffer
+}
+
+func (r *TextRenderer) Render(e *sdjournal.JournalEntry) []byte {
+	r.buf.Reset()
+
+	t := time.Unix(0, int64(e.RealtimeTimestamp)*int64(time.Microsecond))
+	if r.utc {
+		t = t.UTC()
+	}
+
+	switch r.format {
+	case OutputCat:
+		r.buf.WriteString(printableValue(e.Fields["MESSAGE"]))
+		r.buf.WriteByte('\n')
+		return r.buf.Bytes()
+	case OutputVerbose:
+		r.renderVerbose(t, e)
+		return r.buf.Bytes()
+	}
+
+	switch r.format {
+	case OutputShort:
+		r.buf.WriteString(t.Format("Jan 02 15:04:05"))
+	case OutputShortISO:
+		r.buf.WriteString(t.Format("2006-01-02T15:04:05-0700"))
+	case OutputShortPrecise:
+		r.buf.WriteString(t.Format("Jan 02 15:04:05.000000"))
+	case OutputWithUnit:
+		r.buf.WriteString(t.Format("Mon 2006-01-02 15:04:05 MST"))
+	}
+
+	if hostname := e.Fields["_HOSTNAME"]; hostname != "" {
+		r.buf.WriteByte(' ')
+		r.buf.WriteString(hostname)
+	}
+
+	identifier := firstField(e, "SYSLOG_IDENTIFIER", "_COMM")
+	if r.format == OutputWithUnit {
+		if unit := firstField(e, "_SYSTEMD_UNIT", "_SYSTEMD_USER_UNIT"); unit != "" {
+			identifier = unit
+		}
+	}
+	if identifier != "" {
+		r.buf.WriteByte(' ')
+		r.buf.WriteString(identifier)
+	}
+	if pid := firstField(e, "SYSLOG_PID", "_PID"); pid != "" {
+		r.buf.WriteByte('[')
+		r.buf.WriteString(pid)
+		r.buf.WriteByte(']')
+	}
+	r.buf.WriteString(": ")
+
+	// Continuation lines are aligned with the first one, like journalctl
+	// does.
+	indent := "\n" + strings.Repeat(" ", r.buf.Len())
+	message := strings.TrimRight(printableValue(e.Fields["MESSAGE"]), "\n")
+	r.buf.WriteString(strings.Replace(message, "\n", indent, -1))
+	r.buf.WriteByte('\n')
+	return r.buf.Bytes()
+}
+
+func (r *TextRenderer) renderVerbose(t time.Time, e *sdjournal.JournalEntry) {
+	r.buf.WriteString(t.Format("Mon 2006-01-02 15:04:05.000000 MST"))
+	r.buf.WriteString(" [")
+	r.buf.WriteString(e.Cursor)
+	r.buf.WriteString("]\n")
+
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.buf.WriteString("    ")
+		r.buf.WriteString(key)
+		r.buf.WriteByte('=')
+		r.buf.WriteString(printableValue(e.Fields[key]))
+		r.buf.WriteByte('\n')
+	}
+}
+
+// TemplateEntry is what templates of the template output format are executed
+// with, e.g. {{.Time.Format "15:04:05"}} {{.Fields.MESSAGE}}.
+type TemplateEntry struct {
+	Cursor    string
+	Time      time.Time
+	Monotonic time.Duration
+	Fields    map[string]string
+}
+
+// TemplateRenderer renders entries with a text/template.
+type TemplateRenderer struct {
+	template *template.Template
+	utc      bool
+	buf      bytes.Buffer
+}
+
+func (r *TemplateRenderer) Render(e *sdjournal.JournalEntry) []byte {
+	r.buf.Reset()
+
+	t := time.Unix(0, int64(e.RealtimeTimestamp)*int64(time.Microsecond))
+	if r.utc {
+		t = t.UTC()
+	}
+
+	err := r.template.Execute(&r.buf, TemplateEntry{
+		Cursor:    e.Cursor,
+		Time:      t,
+		Monotonic: time.Duration(e.MonotonicTimestamp) * time.Microsecond,
+		Fields:    e.Fields,
+	})
+	if err != nil {
+		r.buf.Reset()
+		fmt.Fprintf(&r.buf, "[template error: %v]", err)
+	}
+
+	if r.buf.Len() == 0 || r.buf.Bytes()[r.buf.Len()-1] != '\n' {
+		r.buf.WriteByte('\n')
+	}
+	return r.buf.Bytes()
+}
+
+func firstField(e *sdjournal.JournalEntry, keys ...string) string {
+	for _, key := range keys {
+		if value := e.Fields[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// printableValue replaces binary values with a placeholder, like journalctl
+// does.
+func printableValue(value string) string {
+	if utf8.ValidString(value) {
+		return value
+	}
+	return fmt.Sprintf("[%dB blob data]", len(value))
+}
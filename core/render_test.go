@@ -0,0 +1,109 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// newTestRenderEntry creates an entry logged on Thu 2017-01-05 03:04:05.000007
+// UTC, single digit day included to check padding.
+func newTestRenderEntry(message string) *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{
+		Cursor:             "s=1;i=2",
+		RealtimeTimestamp:  1483585445000007,
+		MonotonicTimestamp: 1500000,
+		Fields: map[string]string{
+			"MESSAGE":           message,
+			"_HOSTNAME":         "node-1",
+			"SYSLOG_IDENTIFIER": "sshd",
+			"_PID":              "42",
+			"_SYSTEMD_UNIT":     "ssh.service",
+		},
+	}
+}
+
+func render(t *testing.T, config RendererConfig, e *sdjournal.JournalEntry) string {
+	config.UTC = true
+	r, err := NewRendererWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(r.Render(e))
+}
+
+// TestTextRendererMatchesJournalctl checks every format against what
+// `journalctl -o <format>` prints for the same entry, in UTC.
+func TestTextRendererMatchesJournalctl(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{OutputShort, "Jan 05 03:04:05 node-1 sshd[42]: Accepted publickey\n"},
+		{OutputShortISO, "2017-01-05T03:04:05+0000 node-1 sshd[42]: Accepted publickey\n"},
+		{OutputShortPrecise, "Jan 05 03:04:05.000007 node-1 sshd[42]: Accepted publickey\n"},
+		{OutputWithUnit, "Thu 2017-01-05 03:04:05 UTC node-1 ssh.service[42]: Accepted publickey\n"},
+		{OutputCat, "Accepted publickey\n"},
+		{OutputVerbose, "Thu 2017-01-05 03:04:05.000007 UTC [s=1;i=2]\n" +
+			"    MESSAGE=Accepted publickey\n" +
+			"    SYSLOG_IDENTIFIER=sshd\n" +
+			"    _HOSTNAME=node-1\n" +
+			"    _PID=42\n" +
+			"    _SYSTEMD_UNIT=ssh.service\n"},
+	}
+	for _, test := range tests {
+		config := NewRendererConfig()
+		config.Format = test.format
+		if got := render(t, config, newTestRenderEntry("Accepted publickey")); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestTextRendererMultilineAndBinary(t *testing.T) {
+	config := NewRendererConfig()
+	config.Format = OutputShort
+	want := "Jan 05 03:04:05 node-1 sshd[42]: first\n" +
+		"                                 second\n"
+	if got := render(t, config, newTestRenderEntry("first\nsecond\n")); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := render(t, config, newTestRenderEntry("\xff\x00\x01")); got != "Jan 05 03:04:05 node-1 sshd[42]: [3B blob data]\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTextRendererFallbackFields(t *testing.T) {
+	e := &sdjournal.JournalEntry{
+		RealtimeTimestamp: 1483585445000007,
+		Fields:            map[string]string{"MESSAGE": "hi", "_COMM": "cron", "SYSLOG_PID": "7"},
+	}
+	config := NewRendererConfig()
+	config.Format = OutputShort
+	if got := render(t, config, e); got != "Jan 05 03:04:05 cron[7]: hi\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	config := NewRendererConfig()
+	config.Format = OutputTemplate
+	config.Template = `{{.Time.Format "15:04:05"}} {{.Fields._SYSTEMD_UNIT}} {{.Monotonic}} {{.Fields.MISSING}}{{.Fields.MESSAGE}}`
+	if got := render(t, config, newTestRenderEntry("hi")); got != "03:04:05 ssh.service 1.5s hi\n" {
+		t.Errorf("got %q", got)
+	}
+
+	config.Template = `{{.Nope}}`
+	if got := render(t, config, newTestRenderEntry("hi")); got[:16] != "[template error:" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestJSONRenderer(t *testing.T) {
+	config := NewRendererConfig()
+	got := render(t, config, newTestRenderEntry("hi"))
+	if got[0] != '{' || got[len(got)-1] != '\n' {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// secretReloadInterval is how often secret files are checked for changes.
+const secretReloadInterval = 10 * time.Second
+
+// redacted is what secrets look like when formatted.
+const redacted = "<redacted>"
+
+// Secret is a credential, given as is or read from a file. Files are checked
+// for changes every now and then, so that rotated credentials are picked up
+// without a restart. Secrets are never formatted with their value.
+type Secret struct {
+	path string
+
+	mu      sync.RWMutex
+	value   string
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewSecret creates a Secret from its value if path is empty, otherwise from
+// the contents of the file at path, surrounding whitespace trimmed.
+func NewSecret(value, path string) (*Secret, error) {
+	if path == "" {
+		return &Secret{value: value}, nil
+	}
+	if value != "" {
+		return nil, errors.New("secret given both as a value and as a file")
+	}
+
+	s := &Secret{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Value returns the secret, reloading it first if its file changed.
+func (s *Secret) Value() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.RLock()
+	value, stale := s.value, s.path != "" && time.Since(s.checked) >= secretReloadInterval
+	s.mu.RUnlock()
+	if !stale {
+		return value
+	}
+
+	if err := s.reload(); err != nil {
+		// Keep the last good value, files are briefly missing while rotated.
+		log.Printf("unable to reload secret from %s: %v", s.path, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+func (s *Secret) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Now()
+
+	// Stat follows symlinks, kubernetes swaps them on updates.
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.value = strings.TrimSpace(string(data))
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// IsZero reports whether the secret is empty.
+func (s *Secret) IsZero() bool {
+	return s.Value() == ""
+}
+
+// Scrub removes value from err, e.g. a secret from the URL of a failed request.
+// value must be the one the request was made with, as secrets may be rotated
+// in the meantime.
+func Scrub(err error, value string) error {
+	if err == nil || value == "" || !strings.Contains(err.Error(), value) {
+		return err
+	}
+	return errors.New(strings.Replace(err.Error(), value, redacted, -1))
+}
+
+func (s *Secret) String() string {
+	return redacted
+}
+
+func (s *Secret) GoString() string {
+	return redacted
+}
+
+// SecretVar defines a secret valued flag, and another one with the -file
+// suffix to read it from a file instead. The value is never shown as a
+// default in the usage.
+func SecretVar(fs *flag.FlagSet, value, path *string, name, usage string) {
+	fs.StringVar(value, name, "", usage+" prefer --"+name+"-file, values show up in the process list.")
+	fs.StringVar(path, name+"-file", *path, "file to read --"+name+" from, reloaded on changes.")
+}
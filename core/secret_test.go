@@ -0,0 +1,180 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expire makes s check its file on the next call to Value.
+func (s *Secret) expire() {
+	s.mu.Lock()
+	s.checked = time.Time{}
+	s.mu.Unlock()
+}
+
+func newTestSecretFile(t *testing.T, dir, name, value string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretValue(t *testing.T) {
+	s, err := NewSecret("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Value(); v != "token" {
+		t.Fatalf("value is %q", v)
+	}
+	if _, err := NewSecret("token", "token-file"); err == nil {
+		t.Fatal("secret given twice")
+	}
+	if v := (*Secret)(nil).Value(); v != "" {
+		t.Fatalf("nil secret value is %q", v)
+	}
+}
+
+func TestSecretIsNeverFormatted(t *testing.T) {
+	s, _ := NewSecret("token", "")
+	for _, f := range []string{"%v", "%s", "%#v", "%+v"} {
+		if got := fmt.Sprintf(f, s); got != redacted {
+			t.Errorf("%s formats as %q", f, got)
+		}
+	}
+}
+
+func TestSecretReloadsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := newTestSecretFile(t, dir, "token", "first\n")
+
+	s, err := NewSecret("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Value(); v != "first" {
+		t.Fatalf("value is %q", v)
+	}
+
+	newTestSecretFile(t, dir, "token", "second\n")
+	if v := s.Value(); v != "first" {
+		t.Fatalf("reloaded before the interval: %q", v)
+	}
+	s.expire()
+	if v := s.Value(); v != "second" {
+		t.Fatalf("value is %q after the file changed", v)
+	}
+}
+
+// TestSecretReloadsSwappedSymlink rotates the secret as kubernetes updates
+// mounted secrets: the file is a symlink through a directory symlink which is
+// atomically swapped.
+func TestSecretReloadsSwappedSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, version := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newTestSecretFile(t, filepath.Join(dir, "v1"), "token", "first")
+	newTestSecretFile(t, filepath.Join(dir, "v2"), "token", "rotated")
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "token")
+	if err := os.Symlink(filepath.Join("..data", "token"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSecret("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Value(); v != "first" {
+		t.Fatalf("value is %q", v)
+	}
+
+	if err := os.Symlink("v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	s.expire()
+	if v := s.Value(); v != "rotated" {
+		t.Fatalf("value is %q after the symlink was swapped", v)
+	}
+}
+
+func TestSecretKeepsLastGoodValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := newTestSecretFile(t, dir, "token", "first")
+
+	s, err := NewSecret("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Briefly missing while rotated.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s.expire()
+	if v := s.Value(); v != "first" {
+		t.Fatalf("value is %q while the file is missing", v)
+	}
+
+	newTestSecretFile(t, dir, "token", "second")
+	s.expire()
+	if v := s.Value(); v != "second" {
+		t.Fatalf("value is %q once the file is back", v)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := newTestSecretFile(t, dir, "token", "first")
+	s, err := NewSecret("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret is rotated while the request made with it fails.
+	token := s.Value()
+	err = fmt.Errorf("Post https://logs/bulk/%s: connection refused", token)
+	newTestSecretFile(t, dir, "token", "second")
+	s.expire()
+	s.Value()
+
+	if got := Scrub(err, token).Error(); got != "Post https://logs/bulk/"+redacted+": connection refused" {
+		t.Fatalf("scrubbed error is %q", got)
+	}
+
+	other := errors.New("connection refused")
+	if Scrub(other, token) != other || Scrub(nil, token) != nil || Scrub(err, "") != err {
+		t.Fatal("errors without the secret were changed")
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/binary"
+)
+
+// Snappy block format, as described in
+// https://github.com/google/snappy/blob/master/format_description.txt.
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+
+	// Matches are looked for within blocks of this size.
+	snappyMaxBlockSize = 65536
+	// Shorter blocks are encoded as a single literal.
+	snappyMinMatchBlockSize = 17
+
+	snappyTableBits = 14
+)
+
+// snappyEncode appends the snappy block encoding of src to dst.
+func snappyEncode(dst, src []byte) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	dst = append(dst, scratch[:binary.PutUvarint(scratch[:], uint64(len(src)))]...)
+
+	var table [1 << snappyTableBits]int32
+	for len(src) > 0 {
+		p := src
+		if len(p) > snappyMaxBlockSize {
+			p = p[:snappyMaxBlockSize]
+		}
+		src = src[len(p):]
+
+		if len(p) < snappyMinMatchBlockSize {
+			dst = snappyEmitLiteral(dst, p)
+			continue
+		}
+		for i := range table {
+			table[i] = 0
+		}
+		dst = snappyEncodeBlock(dst, p, &table)
+	}
+	return dst
+}
+
+// snappyEncodeBlock greedily replaces every repeated 4 byte sequence of p with
+// a copy of its last occurrence. Table holds the positions of the sequences
+// seen, plus one.
+func snappyEncodeBlock(dst, p []byte, table *[1 << snappyTableBits]int32) []byte {
+	lit := 0
+	for s := 0; s+4 <= len(p); {
+		v := binary.LittleEndian.Uint32(p[s:])
+		h := (v * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(s + 1)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(p[candidate:]) != v {
+			s++
+			continue
+		}
+
+		if lit < s {
+			dst = snappyEmitLiteral(dst, p[lit:s])
+		}
+		length := 4
+		for s+length < len(p) && p[s+length] == p[candidate+length] {
+			length++
+		}
+		dst = snappyEmitCopy(dst, s-candidate, length)
+		s += length
+		lit = s
+	}
+	if lit < len(p) {
+		dst = snappyEmitLiteral(dst, p[lit:])
+	}
+	return dst
+}
+
+func snappyEmitLiteral(dst, lit []byte) []byte {
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyEmitCopy emits a copy of length bytes from offset bytes back, split in
+// as many copies as needed: each of them copies 4 to 64 bytes.
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
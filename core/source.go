@@ -0,0 +1,29 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// Source is anything capable of feeding journal entries into a Forwarder.
+// JournalFollower is the canonical implementation, but other kinds of sources
+// (e.g. in-memory ones used for testing) can be plugged into the forwarding
+// loop as long as they honor the same contract.
+type Source interface {
+	// Follow sends entries to recvc until ctx is done or the source is
+	// exhausted, reporting any non fatal error through errc. It blocks until
+	// then.
+	Follow(ctx context.Context, recvc chan<- *sdjournal.JournalEntry, errc chan<- error)
+
+	// SeekCursor positions the source right after the entry pointed by cursor.
+	SeekCursor(cursor string) error
+
+	// SeekRealtime positions the source at the first entry whose realtime
+	// timestamp is equal or greater than t.
+	SeekRealtime(t time.Time) error
+
+	// Close releases any resource held by the source.
+	Close() error
+}
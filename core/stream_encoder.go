@@ -0,0 +1,98 @@
+package core
+
+import (
+	"io"
+	"sync"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// batchFramer is implemented by encoders able to encode a batch one entry at a
+// time.
+type batchFramer interface {
+	// batchStart returns what goes before the n entries of a batch.
+	batchStart(n int) []byte
+	// batchEntry returns the i-th entry of a batch, along with whatever goes
+	// before it.
+	batchEntry(i int, e *sdjournal.JournalEntry) []byte
+	// batchEnd returns what goes after the entries of a batch.
+	batchEnd() []byte
+}
+
+// StreamEncoder writes encoded entries straight to an io.Writer, so that
+// batches are never held in memory as a whole. Encoders are drawn from a pool,
+// it's safe for concurrent use.
+type StreamEncoder struct {
+	pool sync.Pool
+}
+
+// NewStreamEncoder creates a StreamEncoder for encoding configured with
+// DefaultMarshallerConfig.
+func NewStreamEncoder(encoding string) (*StreamEncoder, error) {
+	return NewStreamEncoderWithConfig(encoding, DefaultMarshallerConfig)
+}
+
+// NewStreamEncoderWithConfig creates a StreamEncoder for encoding configured
+// with config.
+func NewStreamEncoderWithConfig(encoding string, config MarshallerConfig) (*StreamEncoder, error) {
+	if _, err := NewEncoderWithConfig(encoding, config); err != nil {
+		return nil, err
+	}
+
+	s := &StreamEncoder{}
+	s.pool.New = func() interface{} {
+		encoder, _ := NewEncoderWithConfig(encoding, config)
+		return encoder
+	}
+	return s, nil
+}
+
+// ContentType returns the media type of encoded entries.
+func (s *StreamEncoder) ContentType() string {
+	encoder := s.pool.Get().(Encoder)
+	defer s.pool.Put(encoder)
+	return encoder.ContentType()
+}
+
+// Encode writes e to w.
+func (s *StreamEncoder) Encode(w io.Writer, e *sdjournal.JournalEntry) error {
+	encoder := s.pool.Get().(Encoder)
+	defer s.pool.Put(encoder)
+
+	_, err := w.Write(encoder.MarshalOne(e))
+	return err
+}
+
+// Size returns the length of e once encoded.
+func (s *StreamEncoder) Size(e *sdjournal.JournalEntry) int {
+	encoder := s.pool.Get().(Encoder)
+	defer s.pool.Put(encoder)
+
+	return len(encoder.MarshalOne(e))
+}
+
+// EncodeAll writes ea to w as a batch, the same MarshalAll encodes, one entry
+// at a time.
+func (s *StreamEncoder) EncodeAll(w io.Writer, ea []*sdjournal.JournalEntry) error {
+	encoder := s.pool.Get().(Encoder)
+	defer s.pool.Put(encoder)
+	framer := encoder.(batchFramer)
+
+	if err := writeNonEmpty(w, framer.batchStart(len(ea))); err != nil {
+		return err
+	}
+	for i, e := range ea {
+		if _, err := w.Write(framer.batchEntry(i, e)); err != nil {
+			return err
+		}
+	}
+	return writeNonEmpty(w, framer.batchEnd())
+}
+
+func writeNonEmpty(w io.Writer, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := w.Write(p)
+	return err
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// newBenchmarkEntries creates n entries shaped like those of a busy service.
+func newBenchmarkEntries(n int) []*sdjournal.JournalEntry {
+	entries := make([]*sdjournal.JournalEntry, n)
+	for i := range entries {
+		entries[i] = &sdjournal.JournalEntry{
+			Cursor:             fmt.Sprintf("s=bench;i=%x", i+1),
+			RealtimeTimestamp:  1500000000000000 + uint64(i),
+			MonotonicTimestamp: uint64(i),
+			Fields: map[string]string{
+				"MESSAGE":           fmt.Sprintf("GET /api/v1/items/%d 200 12ms", i),
+				"PRIORITY":          "6",
+				"_PID":              "1234",
+				"_UID":              "1000",
+				"_COMM":             "api",
+				"_HOSTNAME":         "node-1",
+				"_SYSTEMD_UNIT":     "api.service",
+				"_BOOT_ID":          "0123456789abcdef0123456789abcdef",
+				"SYSLOG_IDENTIFIER": "api",
+			},
+		}
+	}
+	return entries
+}
+
+func TestStreamEncoderEncodeAllMatchesMarshalAll(t *testing.T) {
+	entries := newBenchmarkEntries(3)
+	config := NewMarshallerConfig()
+	config.SortKeys = true
+	for _, encoding := range []string{EncodingJSON, EncodingMsgpack, EncodingCBOR, EncodingProtobuf} {
+		encoder, err := NewEncoderWithConfig(encoding, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := NewStreamEncoderWithConfig(encoding, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := stream.EncodeAll(&buf, entries); err != nil {
+			t.Fatal(err)
+		}
+		if want := encoder.MarshalAll(entries); !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: streamed batch differs from the marshalled one", encoding)
+		}
+	}
+}
+
+// BenchmarkEncoderMarshalAll encodes batches in memory, as they're buffered
+// before being sent.
+func BenchmarkEncoderMarshalAll(b *testing.B) {
+	entries := newBenchmarkEntries(100)
+	encoder, _ := NewEncoder(EncodingJSON)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ioutil.Discard.Write(encoder.MarshalAll(entries))
+	}
+}
+
+// BenchmarkStreamEncoderEncodeAll encodes batches one entry at a time, as
+// they're streamed to request bodies.
+func BenchmarkStreamEncoderEncodeAll(b *testing.B) {
+	entries := newBenchmarkEntries(100)
+	encoder, _ := NewStreamEncoder(EncodingJSON)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoder.EncodeAll(ioutil.Discard, entries)
+	}
+}
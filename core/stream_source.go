@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+const (
+	// StreamFormatExport is the systemd Journal Export Format.
+	StreamFormatExport = "export"
+	// StreamFormatJSON is the one entry per line `journalctl -o json` format.
+	StreamFormatJSON = "json"
+
+	streamCursorPrefix = "offset="
+)
+
+// StreamSourceConfig represents options to drive the behavior of a StreamSource.
+type StreamSourceConfig struct {
+	// Serialization format of the stream, StreamFormatExport or StreamFormatJSON.
+	Format string
+
+	// File to read the entries from, "-" means stdin.
+	Path string
+}
+
+// StreamSource is a Source which reads serialized entries from a file or from
+// stdin, e.g. journals collected from dead machines or support bundles. Once
+// the end of the stream is reached it stops following.
+//
+// Entry cursors are rewritten as "offset=<bytes>;<__CURSOR>", the offset being
+// where the entry starts, so that resuming is possible by seeking to it. The
+// entry found there must carry the original cursor, otherwise the stream
+// changed and the cursor is looked for from the start instead.
+type StreamSource struct {
+	format string
+	rc     io.ReadCloser
+	reader EntryReader
+	base   int64
+	since  uint64
+
+	// Journal cursor being looked for, and whether it must be the one of the
+	// first entry read.
+	seek   string
+	verify bool
+}
+
+// NewStreamSource opens the stream described by config.
+func NewStreamSource(config StreamSourceConfig) (*StreamSource, error) {
+	if config.Format != StreamFormatExport && config.Format != StreamFormatJSON {
+		return nil, fmt.Errorf("unknown stream format: %s", config.Format)
+	}
+
+	var rc io.ReadCloser = os.Stdin
+	if config.Path != "" && config.Path != "-" {
+		f, err := os.Open(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+
+	s := &StreamSource{format: config.Format, rc: rc}
+	s.reset(0)
+	return s, nil
+}
+
+func (s *StreamSource) reset(base int64) {
+	s.base = base
+	if s.format == StreamFormatJSON {
+		s.reader = NewJSONReader(s.rc)
+	} else {
+		s.reader = NewExportReader(s.rc)
+	}
+}
+
+// SeekCursor positions the stream right after the entry pointed by cursor,
+// which can be either a cursor generated by this source or a plain journal one.
+func (s *StreamSource) SeekCursor(cursor string) error {
+	offset, journalCursor, err := parseStreamCursor(cursor)
+	if err != nil {
+		return err
+	}
+
+	// Either way the entry pointed by cursor is skipped once it's found.
+	s.seek = journalCursor
+	if offset < 0 {
+		// Only the journal cursor is known, it will be searched while following.
+		return nil
+	}
+	s.verify = true
+
+	if seeker, ok := s.rc.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err == nil {
+			s.reset(offset)
+			return nil
+		}
+	}
+
+	// Not seekable (e.g. a pipe), skip the bytes which were already processed.
+	skipped, err := io.CopyN(ioutil.Discard, s.rc, offset-s.base-s.reader.Offset())
+	if err != nil {
+		return fmt.Errorf("unable to skip to offset %d: %v", offset, err)
+	}
+	s.reset(s.base + s.reader.Offset() + skipped)
+	return nil
+}
+
+// rewind goes back to the start of the stream, if it's seekable.
+func (s *StreamSource) rewind() bool {
+	seeker, ok := s.rc.(io.Seeker)
+	if !ok {
+		return false
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	s.reset(0)
+	return true
+}
+
+// SeekRealtime skips every entry older than t.
+func (s *StreamSource) SeekRealtime(t time.Time) error {
+	s.since = uint64(t.UnixNano() / int64(time.Microsecond))
+	return nil
+}
+
+func (s *StreamSource) Close() error {
+	return s.rc.Close()
+}
+
+func (s *StreamSource) Follow(ctx context.Context,
+	recvc chan<- *sdjournal.JournalEntry,
+	errc chan<- error) {
+	report := func(err error) {
+		select {
+		case errc <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	for {
+		start := s.base + s.reader.Offset()
+		e, err := s.reader.ReadEntry()
+		if err == io.EOF {
+			if s.verify && s.rewind() {
+				// The stream ends before the offset, it was rewritten.
+				s.verify = false
+				continue
+			}
+			if s.verify || s.seek != "" {
+				report(fmt.Errorf("cursor %q not found in stream", s.seek))
+			}
+			return
+		}
+		if err != nil {
+			// The stream can't be resynchronized after a parsing error.
+			report(fmt.Errorf("error reading stream at offset %d: %v", s.base+s.reader.Offset(), err))
+			return
+		}
+
+		journalCursor := e.Cursor
+		e.Cursor = formatStreamCursor(start, journalCursor)
+
+		if s.verify {
+			s.verify = false
+			if journalCursor != s.seek {
+				// The stream isn't the one the cursor was taken from, or it
+				// was rewritten: look for the cursor from the start.
+				if !s.rewind() {
+					report(fmt.Errorf("entry at offset %d isn't %q and the stream can't be rewound", start, s.seek))
+					return
+				}
+				continue
+			}
+			s.seek = ""
+			continue
+		}
+		if s.seek != "" {
+			if journalCursor == s.seek {
+				s.seek = ""
+			}
+			continue
+		}
+		if e.RealtimeTimestamp < s.since {
+			continue
+		}
+
+		select {
+		case recvc <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func formatStreamCursor(offset int64, journalCursor string) string {
+	return streamCursorPrefix + strconv.FormatInt(offset, 10) + ";" + journalCursor
+}
+
+// parseStreamCursor splits a cursor into its offset and journal cursor parts.
+// The offset is -1 for plain journal cursors.
+func parseStreamCursor(cursor string) (int64, string, error) {
+	if !strings.HasPrefix(cursor, streamCursorPrefix) {
+		return -1, cursor, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(cursor, streamCursorPrefix), ";", 2)
+	offset, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, "", fmt.Errorf("invalid stream cursor: %s", cursor)
+	}
+	if len(parts) == 1 {
+		return offset, "", nil
+	}
+	return offset, parts[1], nil
+}
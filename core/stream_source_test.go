@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// testExportStream serializes entries whose messages and cursors are ids.
+func testExportStream(ids ...string) string {
+	var b bytes.Buffer
+	for _, id := range ids {
+		fmt.Fprintf(&b, "__CURSOR=%s\nMESSAGE=%s\n\n", id, id)
+	}
+	return b.String()
+}
+
+func writeTestStream(t *testing.T, dir, stream string) string {
+	path := filepath.Join(dir, "stream")
+	if err := ioutil.WriteFile(path, []byte(stream), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// followStream follows s until the stream ends, returning the entries and
+// errors it sent.
+func followStream(s *StreamSource) ([]*sdjournal.JournalEntry, []error) {
+	recvc := make(chan *sdjournal.JournalEntry, 100)
+	errc := make(chan error, 100)
+	s.Follow(context.Background(), recvc, errc)
+	close(recvc)
+	close(errc)
+
+	var entries []*sdjournal.JournalEntry
+	for e := range recvc {
+		entries = append(entries, e)
+	}
+	var errs []error
+	for err := range errc {
+		errs = append(errs, err)
+	}
+	return entries, errs
+}
+
+func messagesOf(entries []*sdjournal.JournalEntry) []string {
+	messages := make([]string, len(entries))
+	for i, e := range entries {
+		messages[i] = e.Fields["MESSAGE"]
+	}
+	return messages
+}
+
+// resumeStream reads path from the entry after cursor.
+func resumeStream(t *testing.T, path, cursor string) ([]string, []error) {
+	s, err := NewStreamSource(StreamSourceConfig{Format: StreamFormatExport, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SeekCursor(cursor); err != nil {
+		t.Fatal(err)
+	}
+	entries, errs := followStream(s)
+	return messagesOf(entries), errs
+}
+
+func TestStreamSourceResumesFromOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestStream(t, dir, testExportStream("a", "b", "c"))
+
+	s, err := NewStreamSource(StreamSourceConfig{Format: StreamFormatExport, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, errs := followStream(s)
+	s.Close()
+	if len(errs) > 0 || len(entries) != 3 {
+		t.Fatalf("got %v, %v", entries, errs)
+	}
+	if want := formatStreamCursor(int64(len(testExportStream("a"))), "b"); entries[1].Cursor != want {
+		t.Fatalf("cursor is %s, want %s", entries[1].Cursor, want)
+	}
+
+	messages, errs := resumeStream(t, path, entries[1].Cursor)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertMessages(t, messages, "c")
+
+	// Plain journal cursors are looked for.
+	messages, errs = resumeStream(t, path, "a")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertMessages(t, messages, "b", "c")
+}
+
+func TestStreamSourceLooksForCursorOfRewrittenStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// b used to be the second entry, another one took its offset.
+	cursor := formatStreamCursor(int64(len(testExportStream("a"))), "b")
+	path := writeTestStream(t, dir, testExportStream("z", "y", "a", "b", "c"))
+	messages, errs := resumeStream(t, path, cursor)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertMessages(t, messages, "c")
+
+	// The stream is now shorter than the offset.
+	cursor = formatStreamCursor(int64(len(testExportStream("a", "b", "c", "d"))), "e")
+	path = writeTestStream(t, dir, testExportStream("d", "e", "f"))
+	messages, errs = resumeStream(t, path, cursor)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertMessages(t, messages, "f")
+}
+
+func TestStreamSourceReportsMissingCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestStream(t, dir, testExportStream("a", "b"))
+
+	for _, cursor := range []string{"x", formatStreamCursor(0, "x")} {
+		messages, errs := resumeStream(t, path, cursor)
+		if len(messages) > 0 || len(errs) != 1 || !strings.Contains(errs[0].Error(), "not found") {
+			t.Errorf("%s: got %v, %v", cursor, messages, errs)
+		}
+	}
+}
+
+func TestStreamSourceVerifiesOffsetOfPipes(t *testing.T) {
+	newPipeSource := func(stream string) *StreamSource {
+		s := &StreamSource{format: StreamFormatExport, rc: ioutil.NopCloser(strings.NewReader(stream))}
+		s.reset(0)
+		return s
+	}
+	cursor := formatStreamCursor(int64(len(testExportStream("a"))), "b")
+
+	s := newPipeSource(testExportStream("a", "b", "c"))
+	if err := s.SeekCursor(cursor); err != nil {
+		t.Fatal(err)
+	}
+	entries, errs := followStream(s)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertMessages(t, messagesOf(entries), "c")
+
+	// A pipe can't be rewound to look for the cursor.
+	s = newPipeSource(testExportStream("z", "y", "c"))
+	if err := s.SeekCursor(cursor); err != nil {
+		t.Fatal(err)
+	}
+	entries, errs = followStream(s)
+	if len(entries) > 0 || len(errs) != 1 || !strings.Contains(errs[0].Error(), "can't be rewound") {
+		t.Fatalf("got %v, %v", entries, errs)
+	}
+}
+
+func TestDefaultCursorPath(t *testing.T) {
+	config := NewForwarderConfig(1)
+	paths := make(map[string]bool)
+	for _, c := range []struct{ source, input string }{
+		{SourceJournal, ""},
+		{SourceRemote, ""},
+		{StreamFormatExport, "-"},
+		{StreamFormatJSON, "-"},
+		{StreamFormatExport, "a.export"},
+		{StreamFormatExport, "b.export"},
+	} {
+		config.Source, config.Input = c.source, c.input
+		path := defaultCursorPath(config)
+		if paths[path] {
+			t.Errorf("%s %s shares %s", c.source, c.input, path)
+		}
+		paths[path] = true
+	}
+
+	config.Source, config.Input = SourceJournal, ""
+	if path := defaultCursorPath(config); path != DefaultCursorPath {
+		t.Errorf("journal cursor path is %s", path)
+	}
+	config.Source, config.Input = StreamFormatExport, "a.export"
+	abs, _ := filepath.Abs("a.export")
+	same := config
+	same.Input = abs
+	if defaultCursorPath(config) != defaultCursorPath(same) {
+		t.Error("relative and absolute paths of an input don't share the cursor")
+	}
+}
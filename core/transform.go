@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// TransformConfig represents the field manipulations done by a TransformStage.
+// They are applied in the order below, pairs are given as "A=B".
+type TransformConfig struct {
+	// Copy SRC=DST fields.
+	Copy []string
+
+	// Rename OLD=NEW fields.
+	Rename []string
+
+	// Drop fields whose name matches any of these glob patterns, e.g.
+	// "_SOURCE_*" or "_CAP_EFFECTIVE".
+	Drop []string
+
+	// Add KEY=VALUE static fields.
+	Add []string
+
+	// Coerce FIELD=TYPE, TYPE being one of int, float, bool, string or
+	// rfc3339 (for microsecond timestamps).
+	Coerce []string
+}
+
+// IsZero reports whether no transformation is configured.
+func (c TransformConfig) IsZero() bool {
+	return len(c.Copy) == 0 && len(c.Rename) == 0 && len(c.Drop) == 0 &&
+		len(c.Add) == 0 && len(c.Coerce) == 0
+}
+
+// TransformStage renames, drops, adds, copies and coerces entry fields.
+type TransformStage struct {
+	copy   [][2]string
+	rename [][2]string
+	drop   []string
+	add    [][2]string
+	coerce map[string]FieldType
+}
+
+// NewTransformStage creates a TransformStage as described by config.
+func NewTransformStage(config TransformConfig) (*TransformStage, error) {
+	var err error
+	s := &TransformStage{
+		drop:   config.Drop,
+		coerce: make(map[string]FieldType),
+	}
+
+	if s.copy, err = splitPairs(config.Copy); err != nil {
+		return nil, err
+	}
+	if s.rename, err = splitPairs(config.Rename); err != nil {
+		return nil, err
+	}
+	if s.add, err = splitPairs(config.Add); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range s.drop {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid drop pattern %q: %v", pattern, err)
+		}
+	}
+
+	coerce, err := splitPairs(config.Coerce)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range coerce {
+		t, err := ParseFieldType(p[1])
+		if err != nil {
+			return nil, err
+		}
+		s.coerce[p[0]] = t
+	}
+
+	return s, nil
+}
+
+func (s *TransformStage) Process(e *sdjournal.JournalEntry, emit func(*sdjournal.JournalEntry)) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+
+	for _, p := range s.copy {
+		if v, ok := e.Fields[p[0]]; ok {
+			e.Fields[p[1]] = v
+		}
+	}
+
+	for _, p := range s.rename {
+		if v, ok := e.Fields[p[0]]; ok {
+			delete(e.Fields, p[0])
+			e.Fields[p[1]] = v
+		}
+	}
+
+	if len(s.drop) > 0 {
+		for key := range e.Fields {
+			if matchAny(s.drop, key) {
+				delete(e.Fields, key)
+			}
+		}
+	}
+
+	for _, p := range s.add {
+		e.Fields[p[0]] = p[1]
+	}
+
+	for key, t := range s.coerce {
+		if v, ok := e.Fields[key]; ok {
+			e.Fields[key] = coerceValue(v, t)
+		}
+	}
+
+	emit(e)
+}
+
+func (s *TransformStage) Flush(now time.Time, emit func(*sdjournal.JournalEntry)) {
+}
+
+// FieldTypes returns the types fields are coerced to.
+func (s *TransformStage) FieldTypes() map[string]FieldType {
+	return s.coerce
+}
+
+// coerceValue normalizes v to its textual representation as type t. Values
+// which can't be represented are left untouched, and marshallers encode them
+// as strings.
+func coerceValue(v string, t FieldType) string {
+	v = strings.TrimSpace(v)
+	switch t {
+	case FieldInt:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+	case FieldFloat:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case FieldBool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case FieldTime:
+		if usec, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return FormatUsec(usec)
+		}
+	}
+	return v
+}
+
+// FormatUsec formats a journal microsecond timestamp as RFC3339 in UTC.
+func FormatUsec(usec uint64) string {
+	return time.Unix(0, int64(usec)*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+}
+
+// splitPairs splits "A=B" values into pairs.
+func splitPairs(values []string) ([][2]string, error) {
+	pairs := make([][2]string, 0, len(values))
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid pair %q, expected A=B", v)
+		}
+		pairs = append(pairs, [2]string{v[:i], v[i+1:]})
+	}
+	return pairs, nil
+}
+
+// matchAny reports whether name matches any of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
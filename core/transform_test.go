@@ -0,0 +1,144 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// processEntry runs e through s, which must emit it right away.
+func processEntry(t *testing.T, s Stage, e *sdjournal.JournalEntry) *sdjournal.JournalEntry {
+	var emitted []*sdjournal.JournalEntry
+	s.Process(e, func(e *sdjournal.JournalEntry) {
+		emitted = append(emitted, e)
+	})
+	if len(emitted) != 1 {
+		t.Fatalf("%d entries emitted", len(emitted))
+	}
+	return emitted[0]
+}
+
+func TestTransformStage(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TransformConfig
+		fields map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "rename",
+			config: TransformConfig{Rename: []string{"MESSAGE=msg", "MISSING=x"}},
+			fields: map[string]string{"MESSAGE": "hello", "PRIORITY": "6"},
+			want:   map[string]string{"msg": "hello", "PRIORITY": "6"},
+		},
+		{
+			name:   "rename over existing",
+			config: TransformConfig{Rename: []string{"A=B"}},
+			fields: map[string]string{"A": "1", "B": "2"},
+			want:   map[string]string{"B": "1"},
+		},
+		{
+			name:   "copy",
+			config: TransformConfig{Copy: []string{"_HOSTNAME=host"}},
+			fields: map[string]string{"_HOSTNAME": "node-1"},
+			want:   map[string]string{"_HOSTNAME": "node-1", "host": "node-1"},
+		},
+		{
+			name:   "drop",
+			config: TransformConfig{Drop: []string{"_SOURCE_*", "_CAP_EFFECTIVE"}},
+			fields: map[string]string{"MESSAGE": "hello", "_SOURCE_REALTIME_TIMESTAMP": "1", "_CAP_EFFECTIVE": "0"},
+			want:   map[string]string{"MESSAGE": "hello"},
+		},
+		{
+			name:   "add",
+			config: TransformConfig{Add: []string{"env=prod", "MESSAGE=overwritten", "empty="}},
+			fields: map[string]string{"MESSAGE": "hello"},
+			want:   map[string]string{"MESSAGE": "overwritten", "env": "prod", "empty": ""},
+		},
+		{
+			// Copies and renames are applied before drops and adds, which
+			// are applied before coercion.
+			name: "order",
+			config: TransformConfig{
+				Copy:   []string{"A=B"},
+				Rename: []string{"B=C"},
+				Drop:   []string{"A"},
+				Add:    []string{"D= 42 "},
+				Coerce: []string{"C=int", "D=int"},
+			},
+			fields: map[string]string{"A": "007"},
+			want:   map[string]string{"C": "7", "D": "42"},
+		},
+		{
+			name: "coerce",
+			config: TransformConfig{Coerce: []string{
+				"INT=int", "FLOAT=float", "BOOL=bool", "TIME=rfc3339", "STRING=string", "MISSING=int",
+			}},
+			fields: map[string]string{
+				"INT":    " 0042 ",
+				"FLOAT":  "1.50",
+				"BOOL":   "TRUE",
+				"TIME":   "1483585445000007",
+				"STRING": " 1 ",
+			},
+			want: map[string]string{
+				"INT":    "42",
+				"FLOAT":  "1.5",
+				"BOOL":   "true",
+				"TIME":   "2017-01-05T03:04:05.000007Z",
+				"STRING": "1",
+			},
+		},
+		{
+			name:   "coerce invalid",
+			config: TransformConfig{Coerce: []string{"INT=int", "BOOL=bool", "TIME=rfc3339"}},
+			fields: map[string]string{"INT": "n/a", "BOOL": "maybe", "TIME": "-1"},
+			want:   map[string]string{"INT": "n/a", "BOOL": "maybe", "TIME": "-1"},
+		},
+		{
+			name:   "no fields",
+			config: TransformConfig{Add: []string{"env=prod"}},
+			want:   map[string]string{"env": "prod"},
+		},
+	}
+
+	for _, test := range tests {
+		s, err := NewTransformStage(test.config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		e := processEntry(t, s, &sdjournal.JournalEntry{Fields: test.fields})
+		if !reflect.DeepEqual(e.Fields, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, e.Fields, test.want)
+		}
+	}
+}
+
+func TestTransformStageFieldTypes(t *testing.T) {
+	s, err := NewTransformStage(TransformConfig{Coerce: []string{"A=int", "B=rfc3339"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]FieldType{"A": FieldInt, "B": FieldTime}
+	if got := s.FieldTypes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewTransformStageErrors(t *testing.T) {
+	tests := []TransformConfig{
+		{Rename: []string{"MESSAGE"}},
+		{Copy: []string{"=B"}},
+		{Add: []string{"novalue"}},
+		{Drop: []string{"[unterminated"}},
+		{Coerce: []string{"A=uint"}},
+		{Coerce: []string{"A"}},
+	}
+
+	for _, config := range tests {
+		if _, err := NewTransformStage(config); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// journalWaiter blocks until the journal changes.
+type journalWaiter interface {
+	// Wait returns one of the SD_JOURNAL_* events, or an error if the context
+	// the waiter was created with is done before anything happens.
+	Wait() (int, error)
+	Close() error
+}
+
+// pollableJournal is implemented by journals exposing the underlying
+// sd_journal_get_fd, sd_journal_process and sd_journal_get_timeout calls.
+type pollableJournal interface {
+	GetFd() (int, error)
+	Process() (int, error)
+	GetTimeout() (time.Duration, error)
+}
+
+// pollInterval bounds how long sd_journal_wait blocks when the journal fd
+// can't be used, and therefore how long it takes to notice cancellations.
+const pollInterval = 1 * time.Second
+
+// newJournalWaiter returns the most efficient waiter available for j.
+func newJournalWaiter(ctx context.Context, j *sdjournal.Journal) journalWaiter {
+	w, err := newFdWaiter(ctx, j)
+	if err == nil {
+		return w
+	}
+	log.Printf("Polling the journal every %v, its fd can't be used: %v", pollInterval, err)
+	return &timeoutWaiter{ctx, j}
+}
+
+// timeoutWaiter relies on sd_journal_wait with a timeout, checking for
+// cancellation between calls.
+type timeoutWaiter struct {
+	ctx     context.Context
+	journal *sdjournal.Journal
+}
+
+func (w *timeoutWaiter) Wait() (int, error) {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return sdjournal.SD_JOURNAL_NOP, w.ctx.Err()
+		default:
+		}
+
+		if event := w.journal.Wait(pollInterval); event != sdjournal.SD_JOURNAL_NOP {
+			return event, nil
+		}
+	}
+}
+
+func (w *timeoutWaiter) Close() error {
+	return nil
+}
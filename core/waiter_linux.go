@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// fdWaiter sleeps on the journal file descriptor using epoll. Cancellation is
+// signaled through a pipe registered in the same epoll instance, so idle
+// followers don't wake up at all unless sd-journal asks for it through
+// sd_journal_get_timeout (e.g. for files inotify can't watch).
+type fdWaiter struct {
+	ctx     context.Context
+	journal pollableJournal
+	epfd    int
+	pipe    [2]int
+	closec  chan bool
+	exitc   chan bool
+}
+
+func newFdWaiter(ctx context.Context, j pollableJournal) (journalWaiter, error) {
+	fd, err := j.GetFd()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fdWaiter{
+		ctx:     ctx,
+		journal: j,
+		epfd:    -1,
+		pipe:    [2]int{-1, -1},
+	}
+
+	w.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Pipe2(w.pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		w.closeFds()
+		return nil, err
+	}
+	for _, f := range []int{fd, w.pipe[0]} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(f)}
+		if err = syscall.EpollCtl(w.epfd, syscall.EPOLL_CTL_ADD, f, &event); err != nil {
+			w.closeFds()
+			return nil, err
+		}
+	}
+
+	// Wake epoll up once ctx is done.
+	w.closec = make(chan bool)
+	w.exitc = make(chan bool)
+	go func() {
+		defer close(w.exitc)
+		select {
+		case <-ctx.Done():
+			syscall.Write(w.pipe[1], []byte{0})
+		case <-w.closec:
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *fdWaiter) Wait() (int, error) {
+	timeout, err := w.epollTimeout()
+	if err != nil {
+		return sdjournal.SD_JOURNAL_NOP, err
+	}
+
+	events := make([]syscall.EpollEvent, 2)
+	for {
+		n, err := syscall.EpollWait(w.epfd, events, timeout)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return sdjournal.SD_JOURNAL_NOP, err
+		}
+
+		for _, event := range events[:n] {
+			if int(event.Fd) == w.pipe[0] {
+				return sdjournal.SD_JOURNAL_NOP, w.ctx.Err()
+			}
+		}
+
+		// Either the journal fd was signaled or we timed out, let sd-journal
+		// find out what happened.
+		return w.journal.Process()
+	}
+}
+
+// epollTimeout returns the epoll_wait timeout in milliseconds asked for by
+// sd-journal, -1 to block until something is signaled.
+func (w *fdWaiter) epollTimeout() (int, error) {
+	timeout, err := w.journal.GetTimeout()
+	if err != nil {
+		return 0, err
+	}
+	if timeout == sdjournal.IndefiniteWait {
+		return -1, nil
+	}
+
+	// Round up so that sd-journal's deadline has passed once we wake up.
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	if ms > 1<<31-1 {
+		return -1, nil
+	}
+	return int(ms), nil
+}
+
+func (w *fdWaiter) Close() error {
+	close(w.closec)
+	<-w.exitc
+	w.closeFds()
+	return nil
+}
+
+func (w *fdWaiter) closeFds() {
+	for _, fd := range []int{w.pipe[0], w.pipe[1], w.epfd} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
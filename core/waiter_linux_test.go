@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/go-systemd/sdjournal"
+)
+
+// testPollableJournal signals changes through a pipe standing in for the
+// journal fd.
+type testPollableJournal struct {
+	pipe      [2]int
+	timeout   time.Duration
+	processed chan bool
+}
+
+func newTestPollableJournal(t *testing.T, timeout time.Duration) *testPollableJournal {
+	j := &testPollableJournal{timeout: timeout, processed: make(chan bool, 10)}
+	if err := syscall.Pipe2(j.pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func (j *testPollableJournal) GetFd() (int, error) {
+	return j.pipe[0], nil
+}
+
+func (j *testPollableJournal) Process() (int, error) {
+	j.processed <- true
+	if n, _ := syscall.Read(j.pipe[0], make([]byte, 16)); n > 0 {
+		return sdjournal.SD_JOURNAL_APPEND, nil
+	}
+	return sdjournal.SD_JOURNAL_NOP, nil
+}
+
+func (j *testPollableJournal) GetTimeout() (time.Duration, error) {
+	return j.timeout, nil
+}
+
+func (j *testPollableJournal) Close() {
+	syscall.Close(j.pipe[0])
+	syscall.Close(j.pipe[1])
+}
+
+func newTestFdWaiter(t *testing.T, ctx context.Context, j *testPollableJournal) journalWaiter {
+	w, err := newFdWaiter(ctx, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestFdWaiterWakesUpOnJournalChanges(t *testing.T) {
+	j := newTestPollableJournal(t, sdjournal.IndefiniteWait)
+	defer j.Close()
+	w := newTestFdWaiter(t, context.Background(), j)
+	defer w.Close()
+
+	time.AfterFunc(10*time.Millisecond, func() { syscall.Write(j.pipe[1], []byte{0}) })
+	event, err := w.Wait()
+	if err != nil || event != sdjournal.SD_JOURNAL_APPEND {
+		t.Fatalf("got %d, %v", event, err)
+	}
+}
+
+func TestFdWaiterWakesUpOnCancellation(t *testing.T) {
+	j := newTestPollableJournal(t, sdjournal.IndefiniteWait)
+	defer j.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestFdWaiter(t, ctx, j)
+	defer w.Close()
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := w.Wait(); err != context.Canceled {
+		t.Fatalf("got %v", err)
+	}
+	select {
+	case <-j.processed:
+		t.Fatal("journal processed without being signaled")
+	default:
+	}
+}
+
+func TestFdWaiterHonorsJournalTimeout(t *testing.T) {
+	j := newTestPollableJournal(t, 20*time.Millisecond)
+	defer j.Close()
+	w := newTestFdWaiter(t, context.Background(), j)
+	defer w.Close()
+
+	start := time.Now()
+	event, err := w.Wait()
+	if err != nil || event != sdjournal.SD_JOURNAL_NOP {
+		t.Fatalf("got %d, %v", event, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("woke up after %v", elapsed)
+	}
+	<-j.processed
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+func newFdWaiter(ctx context.Context, j pollableJournal) (journalWaiter, error) {
+	return nil, errors.New("journal fd polling is only supported on linux")
+}
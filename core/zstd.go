@@ -0,0 +1,70 @@
+//go:build zstd
+// +build zstd
+
+package core
+
+/*
+#cgo LDFLAGS: -lzstd
+#include <zstd.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+)
+
+// zstdSupported tells whether zstd compression was built in.
+const zstdSupported = true
+
+// zstdCompressor compresses through libzstd, only built with the zstd build
+// tag.
+type zstdCompressor struct {
+	level int
+}
+
+func newZstdCompressor(level int) (compressor, error) {
+	if level == 0 {
+		level = 3
+	}
+	if level < 1 || level > int(C.ZSTD_maxCLevel()) {
+		return nil, errors.New("invalid zstd compression level")
+	}
+	return &zstdCompressor{level: level}, nil
+}
+
+func (c *zstdCompressor) compress(dst *bytes.Buffer, src []byte) error {
+	bound := int(C.ZSTD_compressBound(C.size_t(len(src))))
+	out := make([]byte, bound)
+
+	var in unsafe.Pointer
+	if len(src) > 0 {
+		in = unsafe.Pointer(&src[0])
+	}
+	n := C.ZSTD_compress(unsafe.Pointer(&out[0]), C.size_t(bound), in, C.size_t(len(src)), C.int(c.level))
+	if C.ZSTD_isError(n) != 0 {
+		return errors.New(C.GoString(C.ZSTD_getErrorName(n)))
+	}
+
+	dst.Write(out[:n])
+	return nil
+}
+
+// zstdDecompress decompresses a frame compressed by zstdCompressor, which
+// always records the size of its content.
+func zstdDecompress(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errors.New("empty zstd frame")
+	}
+	size := C.ZSTD_getFrameContentSize(unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	if size == C.ZSTD_CONTENTSIZE_UNKNOWN || size == C.ZSTD_CONTENTSIZE_ERROR {
+		return nil, errors.New("invalid zstd frame")
+	}
+	out := make([]byte, int(size)+1)
+	n := C.ZSTD_decompress(unsafe.Pointer(&out[0]), C.size_t(len(out)), unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	if C.ZSTD_isError(n) != 0 {
+		return nil, errors.New(C.GoString(C.ZSTD_getErrorName(n)))
+	}
+	return out[:n], nil
+}
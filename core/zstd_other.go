@@ -0,0 +1,15 @@
+//go:build !zstd
+// +build !zstd
+
+package core
+
+import (
+	"errors"
+)
+
+// zstdSupported tells whether zstd compression was built in.
+const zstdSupported = false
+
+func newZstdCompressor(level int) (compressor, error) {
+	return nil, errors.New("zstd support requires building with the zstd tag")
+}
@@ -0,0 +1,22 @@
+//go:build zstd
+// +build zstd
+
+package core
+
+import (
+	"testing"
+)
+
+func TestZstdCompressorRoundTrip(t *testing.T) {
+	for _, level := range []int{0, 1, 19} {
+		testCompressorRoundTrip(t, CompressionZstd, level, zstdDecompress)
+	}
+}
+
+func TestZstdCompressorLevels(t *testing.T) {
+	for _, level := range []int{-1, 100} {
+		if _, err := newZstdCompressor(level); err == nil {
+			t.Errorf("level %d accepted", level)
+		}
+	}
+}
@@ -11,7 +11,7 @@ func main() {
 		ProviderConfig: NewLogglyProviderConfig(),
 		Flags: func(pc core.ProviderConfig, fs *flag.FlagSet) {
 			lc := pc.(*LogglyProviderConfig)
-			fs.StringVar(&lc.Token, "loggly-token", lc.Token, "loggly token")
+			core.SecretVar(fs, &lc.Token, &lc.TokenFile, "loggly-token", "loggly token.")
 			fs.StringVar(&lc.Tags, "loggly-tags", lc.Tags, "loggly tags")
 		},
 		Provider: func(pc core.ProviderConfig) (core.Provider, error) {
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 
+	"github.com/glerchundi/go-systemd/sdjournal"
 	"github.com/glerchundi/journald-forwarder/core"
 )
 
 type LogglyProviderConfig struct {
-	Token string
-	Tags  string
+	Token     string
+	TokenFile string
+	Tags      string
 }
 
 func (*LogglyProviderConfig) Name() string {
@@ -20,7 +22,12 @@ func (*LogglyProviderConfig) Name() string {
 }
 
 func (*LogglyProviderConfig) BulkSize() int {
-	return 1
+	return 100
+}
+
+// BatchLimits returns the limits of the bulk endpoint.
+func (*LogglyProviderConfig) BatchLimits() core.BatchConfig {
+	return core.BatchConfig{MaxBytes: 5 * 1024 * 1024}
 }
 
 func NewLogglyProviderConfig() *LogglyProviderConfig {
@@ -28,58 +35,91 @@ func NewLogglyProviderConfig() *LogglyProviderConfig {
 }
 
 type LogglyProvider struct {
-	client     *http.Client
-	endpoint   string
-	tags       string
-	marshaller core.JournalEntryMarshaller
+	client   *core.HTTPClient
+	endpoint string
+	token    *core.Secret
+	tags     string
+	encoder  *core.StreamEncoder
 }
 
 func NewLogglyProvider(config *LogglyProviderConfig) (*LogglyProvider, error) {
-	if config.Token == "" {
+	token, err := core.NewSecret(config.Token, config.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if token.IsZero() {
 		return nil, errors.New("token not provided")
 	}
 
+	// Loggly only understands JSON
+	encoder, err := core.NewStreamEncoder(core.EncodingJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := core.NewHTTPClient(core.CompressionGzip)
+	if err != nil {
+		return nil, err
+	}
+
 	return &LogglyProvider{
-		client:     &http.Client{},
-		endpoint:   "https://logs-01.loggly.com/bulk/" + config.Token,
-		tags:       config.Tags,
-		marshaller: core.JournalEntryMarshaller{},
+		client:   client,
+		endpoint: "https://logs-01.loggly.com/bulk/",
+		token:    token,
+		tags:     config.Tags,
+		encoder:  encoder,
 	}, nil
 }
 
+// EntrySize returns the size of e in a bulk request, newline included.
+func (lp *LogglyProvider) EntrySize(e *sdjournal.JournalEntry) int {
+	return lp.encoder.Size(e) + 1
+}
+
 func (lp *LogglyProvider) Publish(iterator core.JournalEntryIterator) (int, error) {
-	if !iterator.Next() {
+	var entries []*sdjournal.JournalEntry
+	for iterator.Next() {
+		_, e := iterator.Value()
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
 		return 0, nil
 	}
 
-	_, e := iterator.Value()
-	body := lp.marshaller.MarshalOne(e)
-
 	// propagate!
-	req, err := http.NewRequest("POST", lp.endpoint, bytes.NewBuffer(body))
-	if err != nil {
-		return -1, err
+	header := http.Header{}
+	if lp.tags != "" {
+		header.Add("X-Loggly-Tag", lp.tags)
 	}
 
-	req.Header.Add("User-Agent", "journald-forwarder (version: 0.1.0)")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Content-Length", string(len(body)))
-
-	if lp.tags != "" {
-		req.Header.Add("X-Loggly-Tag", lp.tags)
+	// Bulk events are separated by newlines, and encoded as the body is sent
+	write := func(w io.Writer) error {
+		for _, e := range entries {
+			if err := lp.encoder.Encode(w, e); err != nil {
+				return err
+			}
+			if _, err := w.Write(newline); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	res, err := lp.client.Do(req)
+	// The token is part of the url, keep it out of errors
+	token := lp.token.Value()
+	res, err := lp.client.PostStream(lp.endpoint+token, lp.encoder.ContentType(), write, header)
 	if err != nil {
-		return -1, err
+		return -1, core.Scrub(err, token)
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode >= 400 {
 		resp, _ := ioutil.ReadAll(res.Body)
-		return -1, fmt.Errorf("failed to post to loggly: %v", resp)
+		return -1, fmt.Errorf("failed to post to loggly: %s: %s", res.Status, resp)
 	}
 
-	return 1, nil
-}
\ No newline at end of file
+	return len(entries), nil
+}
+
+var newline = []byte{'\n'}
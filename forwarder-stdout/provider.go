@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"sync"
+
 	"github.com/glerchundi/journald-forwarder/core"
 )
 
@@ -21,19 +23,27 @@ func (*StdoutProviderConfig) BulkSize() int {
 }
 
 type StdoutProvider struct {
-	marshaller core.JournalEntryMarshaller
+	mu       sync.Mutex
+	renderer core.Renderer
 }
 
 func NewStdoutProvider(config *StdoutProviderConfig) (*StdoutProvider, error) {
-	return &StdoutProvider{core.JournalEntryMarshaller{}}, nil
+	renderer, err := core.NewRenderer()
+	if err != nil {
+		return nil, err
+	}
+	return &StdoutProvider{renderer: renderer}, nil
 }
 
 func (sp *StdoutProvider) Publish(iterator core.JournalEntryIterator) (int, error) {
+	// Batches may be published concurrently, don't interleave them
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
 	index := 0
 	for iterator.Next() {
 		i, e := iterator.Value()
-		os.Stdout.WriteString(string(sp.marshaller.MarshalOne(e)))
-		os.Stdout.Write([]byte{'\n','\n'})
+		os.Stdout.Write(sp.renderer.Render(e))
 		index = i
 	}
 